@@ -0,0 +1,448 @@
+package gskma
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+var (
+	stringType    = reflect.TypeOf("")
+	integerRegexp = regexp.MustCompile(`^-?[0-9]+$`)
+)
+
+// rule compiled form of a schema, the keywords are turned into checks with
+// their limits captured so that validation doesn't read the schema again
+// a rule is never modified once compiled, except for the cache of struct fields
+type rule struct {
+	typ    string
+	kind   reflect.Kind
+	def    interface{}
+	reject bool
+	omit   bool
+	quoted bool
+	ref    *rule
+	enum   []interface{}
+
+	strings []check
+	numbers []check
+	objects []check
+	arrays  []check
+
+	props      map[string]*rule
+	required   []string
+	keys       []reflect.Value
+	defaults   []propDefault
+	additional *rule
+	items      *rule
+	allOf      []*rule
+	anyOf      []*rule
+	oneOf      []*rule
+
+	fields sync.Map
+}
+
+// check validate a single keyword of the rule
+type check func(st *state, v reflect.Value)
+
+// field cached validation plan of a struct field, defaults are not assigned
+// to indirect fields since the embedded pointer is shared with the caller
+type field struct {
+	index    []int
+	indirect bool
+	name     string
+	prop     *rule
+	required bool
+}
+
+// propDefault default of a property that is filled in maps when missing
+type propDefault struct {
+	key   reflect.Value
+	value reflect.Value
+}
+
+// compiler compile the schemas reachable from a root, every schema is compiled
+// once so that recursive references become cycles between the rules, the refs
+// to the document the root was copied from are compiled as refs to the root
+type compiler struct {
+	vd    *Validator
+	rules map[*schema]*rule
+	doc   *schema
+	root  *schema
+}
+
+func (c *compiler) compile(s *schema) *rule {
+	if s == c.doc {
+		s = c.root
+	}
+	if r, ok := c.rules[s]; ok {
+		return r
+	}
+
+	r := &rule{
+		typ:      s.Type,
+		kind:     s.rkind,
+		def:      s.Default,
+		reject:   s.reject,
+		omit:     s.omitEmpty,
+		quoted:   s.quoted,
+		enum:     append([]interface{}(nil), s.Enum...),
+		required: append([]string(nil), s.Required...),
+	}
+	c.rules[s] = r
+
+	if s.ref != nil {
+		r.ref = c.compile(s.ref)
+	}
+
+	r.strings = c.stringChecks(s)
+	r.numbers = numberChecks(s)
+	r.objects = objectChecks(s)
+	r.arrays = arrayChecks(s)
+
+	for _, name := range r.required {
+		r.keys = append(r.keys, reflect.ValueOf(name))
+	}
+
+	if len(s.Properties) > 0 {
+		r.props = make(map[string]*rule, len(s.Properties))
+		names := make([]string, 0, len(s.Properties))
+		for name, prop := range s.Properties {
+			r.props[name] = c.compile(prop)
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		for _, name := range names {
+			if def := s.Properties[name].Default; def != nil {
+				if s.Properties[name].quoted {
+					def = fmt.Sprint(def)
+				}
+				r.defaults = append(r.defaults, propDefault{key: reflect.ValueOf(name), value: reflect.ValueOf(def)})
+			}
+		}
+	}
+
+	if s.AdditionalProperties != nil {
+		r.additional = c.compile(s.AdditionalProperties)
+	}
+
+	if s.Items != nil {
+		r.items = c.compile(s.Items)
+	}
+
+	r.allOf = c.compileList(s.AllOf)
+	r.anyOf = c.compileList(s.AnyOf)
+	r.oneOf = c.compileList(s.OneOf)
+	return r
+}
+
+func (c *compiler) compileList(list []schema) []*rule {
+	if len(list) == 0 {
+		return nil
+	}
+
+	rules := make([]*rule, len(list))
+	for i := range list {
+		rules[i] = c.compile(&list[i])
+	}
+	return rules
+}
+
+func (c *compiler) stringChecks(s *schema) []check {
+	var checks []check
+
+	if s.MaxLength != nil {
+		max := *s.MaxLength
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if v.Len() > max {
+				st.report("maxLength", v, max, "value length must not exceed %d character(s)", max)
+			}
+		})
+	}
+
+	if s.MinLength != nil {
+		min := *s.MinLength
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if v.Len() < min {
+				st.report("minLength", v, min, "value length must be at least %d character(s)", min)
+			}
+		})
+	}
+
+	if s.pattern != nil {
+		re, expr := s.pattern, s.Pattern
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if !re.MatchString(v.String()) {
+				st.report("pattern", v, expr, "value must match pattern %s", expr)
+			}
+		})
+	}
+
+	if s.Format != "" && c.vd.assertFormat {
+		name := s.Format
+		if fn, ok := c.vd.format(name); ok {
+			checks = append(checks, func(st *state, v reflect.Value) {
+				if err := fn(v.String()); err != nil {
+					st.report("format", v, name, "%s", err)
+				}
+			})
+		} else if c.vd.rejectUnknown {
+			checks = append(checks, func(st *state, v reflect.Value) {
+				st.report("format", v, name, "unknown format %s", name)
+			})
+		}
+	}
+	return checks
+}
+
+func numberChecks(s *schema) []check {
+	var checks []check
+
+	if s.Maximum != nil {
+		max := *s.Maximum
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if compareNumber(v, max) > 0 {
+				st.report("maximum", v, max, "value must be less than or equal %v", max)
+			}
+		})
+	}
+
+	if s.Minimum != nil {
+		min := *s.Minimum
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if compareNumber(v, min) < 0 {
+				st.report("minimum", v, min, "value must be greater than or equal %v", min)
+			}
+		})
+	}
+
+	if s.ExclusiveMaximum != nil {
+		max := *s.ExclusiveMaximum
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if compareNumber(v, max) >= 0 {
+				st.report("exclusiveMaximum", v, max, "value must be less than %v", max)
+			}
+		})
+	}
+
+	if s.ExclusiveMinimum != nil {
+		min := *s.ExclusiveMinimum
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if compareNumber(v, min) <= 0 {
+				st.report("exclusiveMinimum", v, min, "value must be greater than %v", min)
+			}
+		})
+	}
+
+	if s.MultipleOf != nil && *s.MultipleOf > 0 {
+		mul := *s.MultipleOf
+		checks = append(checks, func(st *state, v reflect.Value) {
+			var rem bool
+			switch v.Kind() {
+			case reflect.String:
+				rem = !divisible(v.String(), mul)
+			case reflect.Float32, reflect.Float64:
+				rem = math.Mod(v.Float(), float64(mul)) != 0
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				rem = v.Uint()%uint64(mul) != 0
+			default:
+				rem = v.Int()%mul != 0
+			}
+
+			if rem {
+				st.report("multipleOf", v, mul, "value must be divisible by %d", mul)
+			}
+		})
+	}
+	return checks
+}
+
+func objectChecks(s *schema) []check {
+	var checks []check
+
+	if s.MaxProperties != nil {
+		max := *s.MaxProperties
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if sizeOf(v) > max {
+				st.report("maxProperties", v, max, "value must not have more than %d item(s)", max)
+			}
+		})
+	}
+
+	if s.MinProperties != nil {
+		min := *s.MinProperties
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if sizeOf(v) < min {
+				st.report("minProperties", v, min, "value must have at least %d item(s)", min)
+			}
+		})
+	}
+	return checks
+}
+
+func arrayChecks(s *schema) []check {
+	var checks []check
+
+	if s.MaxItems != nil {
+		max := *s.MaxItems
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if v.Len() > max {
+				st.report("maxItems", v, max, "value must not have more than %d item(s)", max)
+			}
+		})
+	}
+
+	if s.MinItems != nil {
+		min := *s.MinItems
+		checks = append(checks, func(st *state, v reflect.Value) {
+			if v.Len() < min {
+				st.report("minItems", v, min, "value must have at least %d item(s)", min)
+			}
+		})
+	}
+	return checks
+}
+
+// fieldsOf return the validation plan of the fields of the struct type t
+// the plan is computed once per type and shared by the following validations
+func (r *rule) fieldsOf(t reflect.Type) []field {
+	if fields, ok := r.fields.Load(t); ok {
+		return fields.([]field)
+	}
+
+	discovered := cachedFields(t)
+	fields := make([]field, len(discovered))
+	for i, sf := range discovered {
+		fields[i] = field{
+			index:    sf.index,
+			indirect: sf.indirect,
+			name:     sf.name,
+			prop:     r.props[sf.name],
+			required: contains(r.required, sf.name),
+		}
+	}
+
+	r.fields.Store(t, fields)
+	return fields
+}
+
+// compatible report whether the value has the type of the rule, objects
+// can be either maps or structs and arrays can be either slices or arrays
+func (r *rule) compatible(v reflect.Value) bool {
+	switch r.typ {
+	case "":
+		return true
+	case "object":
+		return v.Kind() == reflect.Map || v.Kind() == reflect.Struct
+	case "array":
+		return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+	case "string":
+		return v.Kind() == reflect.String && v.Type() != numberType
+	case "null":
+		return false
+	case "integer":
+		// integers without a Go kind, like big.Int, have no size limit
+		if r.kind == reflect.Invalid {
+			return isInteger(v)
+		}
+	}
+	return v.Kind() == r.kind
+}
+
+// isInteger report whether v is an integer of any size, json.Number included
+func isInteger(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		return f == math.Trunc(f) && !math.IsInf(f, 0)
+	case reflect.String:
+		return v.Type() == numberType && integerRegexp.MatchString(v.String())
+	}
+	return false
+}
+
+// compareNumber compare the number v with limit, integers are compared
+// exactly even when they are too large to be represented as float64
+func compareNumber(v reflect.Value, limit float64) int {
+	t := math.Trunc(limit)
+
+	var c int
+	switch v.Kind() {
+	case reflect.String:
+		n, ok := parseNumber(v.String())
+		if !ok {
+			return 0
+		}
+		return n.Cmp(big.NewFloat(limit))
+	case reflect.Float32, reflect.Float64:
+		return compare(v.Float() < limit, v.Float() > limit)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch {
+		case limit < 0:
+			return 1
+		case limit >= math.Ldexp(1, 64):
+			return -1
+		}
+		c = compare(v.Uint() < uint64(t), v.Uint() > uint64(t))
+	default:
+		switch {
+		case limit < math.Ldexp(-1, 63):
+			return 1
+		case limit >= math.Ldexp(1, 63):
+			return -1
+		}
+		c = compare(v.Int() < int64(t), v.Int() > int64(t))
+	}
+
+	// the integer equals the integral part of limit, its fraction decides
+	if c == 0 {
+		c = compare(limit > t, limit < t)
+	}
+	return c
+}
+
+// parseNumber parse a json.Number with enough precision to compare large integers exactly
+func parseNumber(s string) (*big.Float, bool) {
+	n, _, err := big.ParseFloat(s, 10, 1024, big.ToNearestEven)
+	return n, err == nil
+}
+
+// divisible report whether the json.Number s is a multiple of mul
+func divisible(s string, mul int64) bool {
+	n, ok := parseNumber(s)
+	if !ok {
+		return true
+	}
+
+	if i, acc := n.Int(nil); acc == big.Exact {
+		return i.Rem(i, big.NewInt(mul)).Sign() == 0
+	}
+
+	f, _ := n.Float64()
+	return math.Mod(f, float64(mul)) == 0
+}
+
+func compare(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+func sizeOf(v reflect.Value) int {
+	if v.Kind() == reflect.Struct {
+		return len(cachedFields(v.Type()))
+	}
+	return v.Len()
+}
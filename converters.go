@@ -1,39 +1,174 @@
 package gskma
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"reflect"
 	"strconv"
 )
 
+var numberType = reflect.TypeOf(json.Number(""))
+
+// converToInt convert v to a signed integer of bitSize bits
+// integers that don't fit in bitSize bits are reported as overflows
 func converToInt(v interface{}, bitSize int) (int64, error) {
-	msg := "value is not of type boolean"
+	msg := "value is not of type integer"
 
+	var value int64
 	switch val := v.(type) {
 	case string:
-		value, err := strconv.ParseInt(val, 10, bitSize)
+		i, err := strconv.ParseInt(val, 10, 64)
 		if err != nil {
-			return 0, fmt.Errorf(msg)
+			return 0, parseError(err, val, "int", bitSize, msg)
 		}
-		return value, nil
+		value = i
 	case int:
-		return int64(val), nil
+		value = int64(val)
 	case int8:
-		return int64(val), nil
+		value = int64(val)
 	case int16:
-		return int64(val), nil
+		value = int64(val)
 	case int32:
-		return int64(val), nil
+		value = int64(val)
 	case int64:
-		return val, nil
+		value = val
+	case uint, uint8, uint16, uint32, uint64:
+		u := reflect.ValueOf(val).Uint()
+		if u > math.MaxInt64 {
+			return 0, overflowError(v, "int", bitSize)
+		}
+		value = int64(u)
+	case float32:
+		return integralFloat(float64(val), bitSize, msg)
+	case float64:
+		return integralFloat(val, bitSize, msg)
+	case json.Number:
+		i, err := strconv.ParseInt(string(val), 10, 64)
+		if err != nil {
+			f, ferr := val.Float64()
+			if ferr != nil {
+				return 0, parseError(err, val, "int", bitSize, msg)
+			}
+			return integralFloat(f, bitSize, msg)
+		}
+		value = i
 	default:
 		return 0, fmt.Errorf(msg)
 	}
+
+	if bitSize < 64 && (value < -1<<(bitSize-1) || value >= 1<<(bitSize-1)) {
+		return 0, overflowError(v, "int", bitSize)
+	}
+	return value, nil
+}
+
+// converToUint convert v to an unsigned integer of bitSize bits
+// negative numbers and integers that don't fit in bitSize bits are reported as overflows
+func converToUint(v interface{}, bitSize int) (uint64, error) {
+	msg := "value is not of type integer"
+
+	var value uint64
+	switch val := v.(type) {
+	case string:
+		u, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			if _, ierr := strconv.ParseInt(val, 10, 64); ierr == nil {
+				return 0, overflowError(v, "uint", bitSize)
+			}
+			return 0, parseError(err, val, "uint", bitSize, msg)
+		}
+		value = u
+	case int, int8, int16, int32, int64:
+		i := reflect.ValueOf(val).Int()
+		if i < 0 {
+			return 0, overflowError(v, "uint", bitSize)
+		}
+		value = uint64(i)
+	case uint:
+		value = uint64(val)
+	case uint8:
+		value = uint64(val)
+	case uint16:
+		value = uint64(val)
+	case uint32:
+		value = uint64(val)
+	case uint64:
+		value = val
+	case float32:
+		return integralUnsignedFloat(float64(val), bitSize, msg)
+	case float64:
+		return integralUnsignedFloat(val, bitSize, msg)
+	case json.Number:
+		u, err := strconv.ParseUint(string(val), 10, 64)
+		if err != nil {
+			f, ferr := val.Float64()
+			if ferr != nil {
+				return 0, parseError(err, val, "uint", bitSize, msg)
+			}
+			return integralUnsignedFloat(f, bitSize, msg)
+		}
+		value = u
+	default:
+		return 0, fmt.Errorf(msg)
+	}
+
+	if bitSize < 64 && value >= 1<<bitSize {
+		return 0, overflowError(v, "uint", bitSize)
+	}
+	return value, nil
+}
+
+// parseError return an overflow error if the parsed text is out of range
+// or an invalid type error otherwise
+func parseError(err error, v interface{}, prefix string, bitSize int, msg string) error {
+	if nerr, ok := err.(*strconv.NumError); ok && nerr.Err == strconv.ErrRange {
+		return overflowError(v, prefix, bitSize)
+	}
+	return fmt.Errorf(msg)
+}
+
+func overflowError(v interface{}, prefix string, bitSize int) error {
+	return fmt.Errorf("value %v overflows %s%d", v, prefix, bitSize)
+}
+
+// integralFloat convert a float without a fractional part that fits in bitSize
+func integralFloat(v float64, bitSize int, msg string) (int64, error) {
+	if v != math.Trunc(v) {
+		return 0, fmt.Errorf(msg)
+	}
+
+	limit := math.Ldexp(1, bitSize-1)
+	if v < -limit || v >= limit {
+		return 0, overflowError(v, "int", bitSize)
+	}
+	return int64(v), nil
+}
+
+// integralUnsignedFloat convert a non negative float without a fractional part that fits in bitSize
+func integralUnsignedFloat(v float64, bitSize int, msg string) (uint64, error) {
+	if v != math.Trunc(v) {
+		return 0, fmt.Errorf(msg)
+	}
+
+	if v < 0 || v >= math.Ldexp(1, bitSize) {
+		return 0, overflowError(v, "uint", bitSize)
+	}
+	return uint64(v), nil
 }
 
 func converToInt64(v interface{}) (int64, error) {
 	return converToInt(v, 64)
 }
 
+func converToPlatformInt(v interface{}) (int, error) {
+	val, err := converToInt(v, strconv.IntSize)
+	if err != nil {
+		return 0, err
+	}
+	return int(val), nil
+}
+
 func converToInt32(v interface{}) (int32, error) {
 	val, err := converToInt(v, 32)
 	if err != nil {
@@ -42,6 +177,58 @@ func converToInt32(v interface{}) (int32, error) {
 	return int32(val), nil
 }
 
+func converToInt16(v interface{}) (int16, error) {
+	val, err := converToInt(v, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int16(val), nil
+}
+
+func converToInt8(v interface{}) (int8, error) {
+	val, err := converToInt(v, 8)
+	if err != nil {
+		return 0, err
+	}
+	return int8(val), nil
+}
+
+func converToUint64(v interface{}) (uint64, error) {
+	return converToUint(v, 64)
+}
+
+func converToPlatformUint(v interface{}) (uint, error) {
+	val, err := converToUint(v, strconv.IntSize)
+	if err != nil {
+		return 0, err
+	}
+	return uint(val), nil
+}
+
+func converToUint32(v interface{}) (uint32, error) {
+	val, err := converToUint(v, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(val), nil
+}
+
+func converToUint16(v interface{}) (uint16, error) {
+	val, err := converToUint(v, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(val), nil
+}
+
+func converToUint8(v interface{}) (uint8, error) {
+	val, err := converToUint(v, 8)
+	if err != nil {
+		return 0, err
+	}
+	return uint8(val), nil
+}
+
 func converToFloat(v interface{}, bitSize int) (float64, error) {
 	tname := "float"
 	if bitSize == 64 {
@@ -60,6 +247,32 @@ func converToFloat(v interface{}, bitSize int) (float64, error) {
 		return float64(val), nil
 	case float64:
 		return val, nil
+	case json.Number:
+		value, err := strconv.ParseFloat(string(val), bitSize)
+		if err != nil {
+			return 0, fmt.Errorf(msg)
+		}
+		return value, nil
+	case int:
+		return float64(val), nil
+	case int8:
+		return float64(val), nil
+	case int16:
+		return float64(val), nil
+	case int32:
+		return float64(val), nil
+	case int64:
+		return float64(val), nil
+	case uint:
+		return float64(val), nil
+	case uint8:
+		return float64(val), nil
+	case uint16:
+		return float64(val), nil
+	case uint32:
+		return float64(val), nil
+	case uint64:
+		return float64(val), nil
 	default:
 		return 0, fmt.Errorf(msg)
 	}
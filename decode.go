@@ -0,0 +1,298 @@
+package gskma
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// SchemaError describe an invalid keyword in a schema document
+type SchemaError struct {
+	// Location JSON Pointer to the invalid keyword in the schema
+	Location string
+	// Message human readable description of the problem
+	Message string
+}
+
+// Error return the message prefixed with the location
+func (e *SchemaError) Error() string {
+	if e.Location == "" {
+		return e.Message
+	}
+	return e.Location + ": " + e.Message
+}
+
+// annotations keywords that are accepted but have no effect on validation
+var annotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"description": true,
+	"examples":    true,
+	"readOnly":    true,
+	"writeOnly":   true,
+	"deprecated":  true,
+}
+
+// keywords the keywords the validator understands mapped to the applicable type
+var keywords = map[string]string{
+	"title":                "",
+	"type":                 "",
+	"format":               "",
+	"default":              "",
+	"enum":                 "",
+	"allOf":                "",
+	"anyOf":                "",
+	"oneOf":                "",
+	"maxLength":            "string",
+	"minLength":            "string",
+	"pattern":              "string",
+	"maximum":              "number",
+	"minimum":              "number",
+	"exclusiveMaximum":     "number",
+	"exclusiveMinimum":     "number",
+	"multipleOf":           "integer",
+	"maxItems":             "array",
+	"minItems":             "array",
+	"items":                "array",
+	"maxProperties":        "object",
+	"minProperties":        "object",
+	"properties":           "object",
+	"additionalProperties": "object",
+	"required":             "object",
+	"$ref":                 "",
+	"$defs":                "",
+	"definitions":          "",
+}
+
+// keywordFields index of the schema struct field of each keyword
+var keywordFields = func() map[string]int {
+	t := reflect.TypeOf(schema{})
+	m := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		name := nameOfField(t.Field(i))
+		if _, ok := keywords[name]; ok {
+			m[name] = i
+		}
+	}
+	return m
+}()
+
+// UnmarshalJSON decode a schema document and derive what the validator needs from it
+// boolean schemas are supported, true accepts any value and false rejects all
+func (s *schema) UnmarshalJSON(in []byte) error {
+	var b bool
+	if err := json.Unmarshal(in, &b); err == nil {
+		*s = schema{reject: !b}
+		return nil
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(in, &doc); err != nil {
+		return &SchemaError{Message: "schema must be an object or a boolean"}
+	}
+
+	*s = schema{}
+	v := reflect.ValueOf(s).Elem()
+
+	for k, raw := range doc {
+		if annotations[k] {
+			continue
+		}
+
+		i, ok := keywordFields[k]
+		if !ok {
+			return &SchemaError{Location: "/" + pointerEscaper.Replace(k), Message: fmt.Sprintf("unsupported keyword %q", k)}
+		}
+
+		var err error
+		switch k {
+		case "properties":
+			s.Properties, err = decodeSchemaMap(raw, k)
+		case "$defs":
+			s.Defs, err = decodeSchemaMap(raw, k)
+		case "definitions":
+			s.Definitions, err = decodeSchemaMap(raw, k)
+		case "items":
+			s.Items, err = decodeSchema(raw, k)
+		case "additionalProperties":
+			s.AdditionalProperties, err = decodeSchema(raw, k)
+		case "allOf", "anyOf", "oneOf":
+			err = decodeSchemaList(raw, v.Field(i), k)
+		case "type":
+			// a list of types is valid json schema, it's not supported since a
+			// schema has a single kind, the same is written with anyOf
+			var list []string
+			if json.Unmarshal(raw, &list) == nil {
+				err = &SchemaError{Location: "/type", Message: fmt.Sprintf("type list %q is not supported, use anyOf", list)}
+			} else if err = json.Unmarshal(raw, &s.Type); err != nil {
+				err = &SchemaError{Location: "/type", Message: err.Error()}
+			}
+		default:
+			if err = json.Unmarshal(raw, v.Field(i).Addr().Interface()); err != nil {
+				err = &SchemaError{Location: "/" + k, Message: err.Error()}
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.init(doc)
+}
+
+func decodeSchemaMap(raw json.RawMessage, keyword string) (map[string]*schema, error) {
+	var list map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, &SchemaError{Location: "/" + keyword, Message: keyword + " must be an object"}
+	}
+
+	schemas := make(map[string]*schema, len(list))
+	for name, r := range list {
+		s, err := decodeSchema(r, keyword, name)
+		if err != nil {
+			return nil, err
+		}
+		schemas[name] = s
+	}
+	return schemas, nil
+}
+
+func decodeSchema(raw json.RawMessage, tokens ...string) (*schema, error) {
+	s := &schema{}
+	if err := s.UnmarshalJSON(raw); err != nil {
+		return nil, prefixError(err, tokens...)
+	}
+	return s, nil
+}
+
+func decodeSchemaList(raw json.RawMessage, field reflect.Value, keyword string) error {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil || len(list) == 0 {
+		return &SchemaError{Location: "/" + keyword, Message: keyword + " must be a non-empty array"}
+	}
+
+	schemas := make([]schema, len(list))
+	for i, r := range list {
+		if err := schemas[i].UnmarshalJSON(r); err != nil {
+			return prefixError(err, keyword, strconv.Itoa(i))
+		}
+	}
+	field.Set(reflect.ValueOf(schemas))
+	return nil
+}
+
+// prefixError prepend the tokens to the location of a SchemaError
+func prefixError(err error, tokens ...string) error {
+	var serr *SchemaError
+	if !errors.As(err, &serr) {
+		serr = &SchemaError{Message: err.Error()}
+	}
+
+	prefix := location{}.at(tokens...).schema
+	return &SchemaError{Location: prefix + serr.Location, Message: serr.Message}
+}
+
+// init check the keywords of a decoded schema and derive the unexported fields
+func (s *schema) init(doc map[string]json.RawMessage) error {
+	kind, err := kindOfType(s.Type, s.Format)
+	if err != nil {
+		return &SchemaError{Location: "/type", Message: err.Error()}
+	}
+	s.rkind = kind
+
+	for k := range doc {
+		t := keywords[k]
+		if s.Type == "" || t == "" || t == s.Type || t == "number" && s.Type == "integer" {
+			continue
+		}
+		return &SchemaError{Location: "/" + k, Message: fmt.Sprintf("%s can be used only with %s schema", k, t)}
+	}
+
+	for k, v := range map[string]*int{
+		"maxLength":     s.MaxLength,
+		"minLength":     s.MinLength,
+		"maxItems":      s.MaxItems,
+		"minItems":      s.MinItems,
+		"maxProperties": s.MaxProperties,
+		"minProperties": s.MinProperties,
+	} {
+		if v != nil && *v < 0 {
+			return &SchemaError{Location: "/" + k, Message: k + " must not be negative"}
+		}
+	}
+
+	if s.MultipleOf != nil && *s.MultipleOf <= 0 {
+		return &SchemaError{Location: "/multipleOf", Message: "multipleOf must be greater than 0"}
+	}
+
+	if s.Pattern != "" {
+		re, err := compilePattern(s.Pattern)
+		if err != nil {
+			return &SchemaError{Location: "/pattern", Message: "invalid pattern: " + err.Error()}
+		}
+		s.pattern = re
+	}
+
+	for i, e := range s.Enum {
+		v, err := castIfNumeric(e, s.rkind)
+		if err != nil {
+			return &SchemaError{Location: "/enum/" + strconv.Itoa(i), Message: err.Error()}
+		}
+		s.Enum[i] = v
+	}
+
+	if s.Default != nil {
+		v, err := castIfNumeric(s.Default, s.rkind)
+		if err != nil {
+			return &SchemaError{Location: "/default", Message: err.Error()}
+		}
+		s.Default = v
+	}
+	return nil
+}
+
+// kindOfType return the kind of the values of a json schema type
+func kindOfType(typ, format string) (reflect.Kind, error) {
+	switch typ {
+	case "":
+		return reflect.Invalid, nil
+	case "string":
+		return reflect.String, nil
+	case "boolean":
+		return reflect.Bool, nil
+	case "integer":
+		for kind, f := range formats {
+			if f == format && types[kind] == "integer" {
+				return kind, nil
+			}
+		}
+		return reflect.Int64, nil
+	case "number":
+		if format == "float" {
+			return reflect.Float32, nil
+		}
+		return reflect.Float64, nil
+	case "object":
+		return reflect.Map, nil
+	case "array":
+		return reflect.Slice, nil
+	case "null":
+		// null has no kind, only missing and nil values are accepted
+		return reflect.Invalid, nil
+	}
+	return reflect.Invalid, fmt.Errorf("unsupported type %q", typ)
+}
+
+// MarshalJSON encode the schema, a schema that rejects all values is encoded as false
+func (s schema) MarshalJSON() ([]byte, error) {
+	if s.reject {
+		return []byte("false"), nil
+	}
+
+	type plain schema
+	return json.Marshal(plain(s))
+}
@@ -0,0 +1,129 @@
+package gskma
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors list of all the violations found while validating a value
+type Errors []error
+
+// Error join the messages of all errors
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is report whether any of the errors matches target
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As find the first error that matches target and set target to it
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidationError describe a single violation of the schema
+type ValidationError struct {
+	// InstanceLocation JSON Pointer (RFC 6901) to the invalid value, empty for the root value
+	InstanceLocation string
+	// Keyword schema keyword that failed e.g. maximum, minLength
+	Keyword string
+	// SchemaLocation JSON Pointer to the failed keyword in the schema
+	SchemaLocation string
+	// Value the invalid value
+	Value interface{}
+	// Limit the value of the failed keyword e.g. 3 for maximum=3
+	Limit interface{}
+	// Message human readable description of the violation
+	Message string
+	// Causes errors of the failed branches for allOf, anyOf and oneOf
+	Causes Errors
+	// Offset byte offset of the value in the validated json document
+	Offset int64
+	// Line 1-based line of the value in the validated json document, 0 if unknown
+	Line int
+	// Column 1-based column of the value in the validated json document
+	Column int
+}
+
+// Error return the message prefixed with the instance location
+// and the position in the document when known
+func (e *ValidationError) Error() string {
+	msg := e.Message
+	if len(e.Causes) > 0 {
+		msg += " [" + e.Causes.Error() + "]"
+	}
+
+	prefix := e.InstanceLocation
+	if e.Line > 0 {
+		if prefix != "" {
+			prefix += " "
+		}
+		prefix += fmt.Sprintf("(line %d, column %d)", e.Line, e.Column)
+	}
+
+	if prefix == "" {
+		return msg
+	}
+	return prefix + ": " + msg
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// location of the validated value in the instance and of its schema
+type location struct {
+	instance string
+	schema   string
+}
+
+// child return the location of a nested value
+func (l location) child(token string, schema ...string) location {
+	c := location{
+		instance: l.instance + "/" + pointerEscaper.Replace(token),
+		schema:   l.schema,
+	}
+	return c.at(schema...)
+}
+
+// at return the location of a subschema or keyword applied to the same value
+func (l location) at(schema ...string) location {
+	for _, t := range schema {
+		if t != "" {
+			l.schema += "/" + pointerEscaper.Replace(t)
+		}
+	}
+	return l
+}
+
+// TagError describe a constraint in the tag of a struct field that can't be applied
+type TagError struct {
+	// Type the struct type the field belongs to
+	Type string
+	// Field the name of the field in the struct
+	Field string
+	// Segment the offending segment of the tag, e.g. maxlen=1O
+	Segment string
+	// Message human readable description of the problem
+	Message string
+}
+
+// Error return the message prefixed with the field and the segment
+func (e *TagError) Error() string {
+	return fmt.Sprintf("%s.%s: %q: %s", e.Type, e.Field, e.Segment, e.Message)
+}
@@ -0,0 +1,169 @@
+package gskma
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// structField field of a struct as encoding/json sees it, fields of
+// embedded structs are promoted and reached through their index path,
+// indirect is set when the path goes through an embedded pointer
+type structField struct {
+	name     string
+	tagged   bool
+	indirect bool
+	index    []int
+	field    reflect.StructField
+	typ      reflect.Type
+}
+
+var fieldCache sync.Map
+
+// cachedFields return the fields of the struct type t, computed once per type
+func cachedFields(t reflect.Type) []structField {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]structField)
+	}
+
+	fields, _ := fieldCache.LoadOrStore(t, structFields(t))
+	return fields.([]structField)
+}
+
+// structFields return the fields encoding/json encodes for the struct type t,
+// unexported fields and fields tagged `json:"-"` are skipped, the fields of
+// embedded structs are promoted unless they're hidden by a field of the same
+// name at a shallower depth, conflicting fields at the same depth are dropped
+// unless exactly one of them is named by its json tag
+func structFields(t reflect.Type) []structField {
+	var current []structField
+	next := []structField{{typ: t}}
+
+	var count, nextCount map[reflect.Type]int
+	visited := map[reflect.Type]bool{}
+
+	var fields []structField
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				unexported := sf.PkgPath != ""
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if unexported && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if unexported {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+
+				name := splitTag(tag)[0]
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					field := structField{name: name, tagged: name != "", indirect: f.indirect, index: index, field: sf, typ: ft}
+					if name == "" {
+						field.name = sf.Name
+					}
+					fields = append(fields, field)
+
+					// the struct is embedded more than once at this depth, the copies
+					// of its fields annihilate each other in the dominance check
+					if count[f.typ] > 1 {
+						fields = append(fields, field)
+					}
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					indirect := f.indirect || sf.Type.Kind() == reflect.Ptr
+					next = append(next, structField{name: ft.Name(), indirect: indirect, index: index, typ: ft})
+				}
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		x, y := fields[i], fields[j]
+		switch {
+		case x.name != y.name:
+			return x.name < y.name
+		case len(x.index) != len(y.index):
+			return len(x.index) < len(y.index)
+		case x.tagged != y.tagged:
+			return x.tagged
+		}
+		return lessIndex(x.index, y.index)
+	})
+
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != fields[i].name {
+				break
+			}
+		}
+
+		// the first field of a name is the shallowest and tagged one, it
+		// dominates unless the next one is as deep and as tagged as it is
+		dominant := fields[i]
+		if advance > 1 && len(dominant.index) == len(fields[i+1].index) && dominant.tagged == fields[i+1].tagged {
+			continue
+		}
+		out = append(out, dominant)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return lessIndex(out[i].index, out[j].index) })
+	return out
+}
+
+func lessIndex(x, y []int) bool {
+	for k, xik := range x {
+		if k >= len(y) {
+			return false
+		}
+		if xik != y[k] {
+			return xik < y[k]
+		}
+	}
+	return len(x) < len(y)
+}
+
+// fieldByIndex return the field of the struct v at the index path, ok is false
+// when the path goes through a nil embedded pointer like encoding/json skips it
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return invalid, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
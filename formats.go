@@ -0,0 +1,204 @@
+package gskma
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	durationRegexp = regexp.MustCompile(`^P(?:(\d+W)|(\d+Y)?(\d+M)?(\d+D)?(?:T(\d+H)?(\d+M)?(\d+S)?)?)$`)
+	uuidRegexp     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	relPtrRegexp   = regexp.MustCompile(`^(0|[1-9][0-9]*)(#|/.*)?$`)
+)
+
+var formatsMu sync.RWMutex
+
+// formatsVersion incremented on every registration so that cached compiled schemas see new checkers
+var formatsVersion uint64
+
+// formatCheckers checkers of the format keyword, built-in and registered ones
+var formatCheckers = map[string]func(string) error{
+	"date-time":             checkDateTime,
+	"date":                  checkDate,
+	"time":                  checkTime,
+	"duration":              checkDuration,
+	"email":                 checkEmail,
+	"hostname":              checkHostname,
+	"ipv4":                  checkIPv4,
+	"ipv6":                  checkIPv6,
+	"uri":                   checkURI,
+	"uri-reference":         checkURIReference,
+	"uuid":                  checkUUID,
+	"json-pointer":          checkJSONPointer,
+	"relative-json-pointer": checkRelativeJSONPointer,
+	"regex":                 checkRegex,
+	"mac":                   checkMAC,
+}
+
+// RegisterFormat register a checker for a custom format, a checker registered
+// with the name of a built-in format replaces it
+// the checker must return an error if the value is not of that format
+func RegisterFormat(name string, fn func(string) error) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formatCheckers[name] = fn
+	atomic.AddUint64(&formatsVersion, 1)
+}
+
+func lookupFormat(name string) (func(string) error, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	fn, ok := formatCheckers[name]
+	return fn, ok
+}
+
+func checkDateTime(v string) error {
+	_, err := time.Parse(time.RFC3339Nano, strings.ToUpper(v))
+	if err != nil {
+		return errors.New("value is not a valid RFC 3339 date-time")
+	}
+	return nil
+}
+
+func checkDate(v string) error {
+	_, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return errors.New("value is not a valid RFC 3339 full-date")
+	}
+	return nil
+}
+
+func checkTime(v string) error {
+	_, err := time.Parse("15:04:05.999999999Z07:00", strings.ToUpper(v))
+	if err != nil {
+		return errors.New("value is not a valid RFC 3339 full-time")
+	}
+	return nil
+}
+
+func checkDuration(v string) error {
+	if !durationRegexp.MatchString(v) || v == "P" || strings.HasSuffix(v, "T") {
+		return errors.New("value is not a valid ISO 8601 duration")
+	}
+	return nil
+}
+
+func checkEmail(v string) error {
+	addr, err := mail.ParseAddress(v)
+	if err != nil || addr.Address != v {
+		return errors.New("value is not a valid email address")
+	}
+	return nil
+}
+
+func checkHostname(v string) error {
+	if len(v) == 0 || len(v) > 253 {
+		return errors.New("hostname length must be between 1 and 253 characters")
+	}
+
+	for _, label := range strings.Split(v, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("hostname label %q length must be between 1 and 63 characters", label)
+		}
+
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("hostname label %q must not start or end with a hyphen", label)
+		}
+
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(isLetter(c) || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("hostname label %q contains invalid character %q", label, c)
+			}
+		}
+	}
+	return nil
+}
+
+func checkIPv4(v string) error {
+	ip := net.ParseIP(v)
+	if ip == nil || strings.Contains(v, ":") {
+		return errors.New("value is not a valid IPv4 address")
+	}
+	return nil
+}
+
+func checkIPv6(v string) error {
+	ip := net.ParseIP(v)
+	if ip == nil || !strings.Contains(v, ":") {
+		return errors.New("value is not a valid IPv6 address")
+	}
+	return nil
+}
+
+func checkURI(v string) error {
+	u, err := url.Parse(v)
+	if err != nil || !u.IsAbs() {
+		return errors.New("value is not a valid absolute URI")
+	}
+	return nil
+}
+
+func checkURIReference(v string) error {
+	_, err := url.Parse(v)
+	if err != nil {
+		return errors.New("value is not a valid URI reference")
+	}
+	return nil
+}
+
+func checkUUID(v string) error {
+	if !uuidRegexp.MatchString(v) {
+		return errors.New("value is not a valid UUID")
+	}
+	return nil
+}
+
+func checkJSONPointer(v string) error {
+	if v != "" && v[0] != '/' {
+		return errors.New("JSON pointer must be empty or start with /")
+	}
+
+	for i := 0; i < len(v); i++ {
+		if v[i] == '~' && (i+1 == len(v) || v[i+1] != '0' && v[i+1] != '1') {
+			return errors.New("JSON pointer contains an invalid ~ escape")
+		}
+	}
+	return nil
+}
+
+func checkRelativeJSONPointer(v string) error {
+	m := relPtrRegexp.FindStringSubmatch(v)
+	if m == nil {
+		return errors.New("value is not a valid relative JSON pointer")
+	}
+
+	if m[2] != "" && m[2] != "#" {
+		return checkJSONPointer(m[2])
+	}
+	return nil
+}
+
+func checkRegex(v string) error {
+	_, err := compilePattern(v)
+	if err != nil {
+		return errors.New("value is not a valid regular expression")
+	}
+	return nil
+}
+
+func checkMAC(v string) error {
+	_, err := net.ParseMAC(v)
+	if err != nil {
+		return errors.New("value is not a valid MAC address")
+	}
+	return nil
+}
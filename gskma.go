@@ -2,34 +2,47 @@ package gskma
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
 	"reflect"
+	"regexp"
+	"strconv"
+	"sync/atomic"
 )
 
 var types = map[reflect.Kind]string{
-	reflect.Int:       "integer",
-	reflect.Int8:      "integer",
-	reflect.Int16:     "integer",
-	reflect.Int32:     "integer",
-	reflect.Int64:     "integer",
-	reflect.Uint:      "integer",
-	reflect.Uint8:     "integer",
-	reflect.Uint16:    "integer",
-	reflect.Uint32:    "integer",
-	reflect.Uint64:    "integer",
-	reflect.Float32:   "number",
-	reflect.Float64:   "number",
-	reflect.Bool:      "boolean",
-	reflect.String:    "string",
-	reflect.Interface: "object",
-	reflect.Map:       "object",
-	reflect.Struct:    "object",
-	reflect.Slice:     "array",
-	reflect.Array:     "array",
+	reflect.Int:     "integer",
+	reflect.Int8:    "integer",
+	reflect.Int16:   "integer",
+	reflect.Int32:   "integer",
+	reflect.Int64:   "integer",
+	reflect.Uint:    "integer",
+	reflect.Uint8:   "integer",
+	reflect.Uint16:  "integer",
+	reflect.Uint32:  "integer",
+	reflect.Uint64:  "integer",
+	reflect.Float32: "number",
+	reflect.Float64: "number",
+	reflect.Bool:    "boolean",
+	reflect.String:  "string",
+	reflect.Map:     "object",
+	reflect.Struct:  "object",
+	reflect.Slice:   "array",
+	reflect.Array:   "array",
 }
 
 var formats = map[reflect.Kind]string{
+	reflect.Int:     "int",
+	reflect.Int8:    "int8",
+	reflect.Int16:   "int16",
 	reflect.Int32:   "int32",
 	reflect.Int64:   "int64",
+	reflect.Uint:    "uint",
+	reflect.Uint8:   "uint8",
+	reflect.Uint16:  "uint16",
+	reflect.Uint32:  "uint32",
+	reflect.Uint64:  "uint64",
 	reflect.Float32: "float",
 	reflect.Float64: "double",
 }
@@ -60,165 +73,339 @@ type schema struct {
 	AllOf                []schema           `json:"allOf,omitempty"`
 	AnyOf                []schema           `json:"anyOf,omitempty"`
 	OneOf                []schema           `json:"oneOf,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Defs                 map[string]*schema `json:"$defs,omitempty"`
+	Definitions          map[string]*schema `json:"definitions,omitempty"`
+	pattern              *regexp.Regexp     `json:"-"`
 	required             bool               `json:"-"`
+	reject               bool               `json:"-"`
+	ref                  *schema            `json:"-"`
 	rkind                reflect.Kind       `json:"-"`
+	omitEmpty            bool               `json:"-"`
+	quoted               bool               `json:"-"`
 }
 
-// Schema schema object
+// Schema schema object, schemas are immutable values, the builder methods return
+// a modified copy so a schema can be shared and validated by many goroutines
+// misuses of the builder methods are recorded in the schema and reported by Err
+// root is the document the $ref of the schema were resolved against, a ref to it
+// is a ref to the schema itself so that the modified copies refer to themselves
 type Schema struct {
-	data schema
+	data  schema
+	root  *schema
+	errs  Errors
+	cache *atomic.Value
+}
+
+// cachedCompile compiled form of a schema for the default validator and the
+// version of the registered formats it was compiled with
+type cachedCompile struct {
+	version  uint64
+	compiled *Compiled
 }
 
 // MarshalJSON marchal json
-func (s *Schema) MarshalJSON() ([]byte, error) {
+func (s Schema) MarshalJSON() ([]byte, error) {
 	return json.Marshal(s.data)
 }
 
-// UnmarshalJSON marchal json
+// UnmarshalJSON load the schema from a json schema document, local $ref are resolved
+// returns a SchemaError if the document uses a keyword that is not supported or is invalid
+// the document is decoded into a schema of its own so copies of s keep their refs
 func (s *Schema) UnmarshalJSON(in []byte) error {
-	return json.Unmarshal(in, &s.data)
+	doc := new(schema)
+	if err := json.Unmarshal(in, doc); err != nil {
+		return err
+	}
+	if err := resolveRefs(doc, "", doc.resolve); err != nil {
+		return err
+	}
+
+	*s = Schema{data: *doc, root: doc}.renew()
+	return nil
+}
+
+// Err return the misuses of the builder methods as Errors of *SchemaError
+// or nil if the schema was built correctly
+func (s Schema) Err() error {
+	if len(s.errs) == 0 {
+		return nil
+	}
+	return s.errs
+}
+
+// Build return the schema and the misuses of the builder methods, if any
+func (s Schema) Build() (Schema, error) {
+	return s, s.Err()
+}
+
+// renew give the schema a compiled form cache of its own, every schema returned by
+// the constructors and builder methods is renewed so that copies never share a cache
+func (s Schema) renew() Schema {
+	s.cache = new(atomic.Value)
+	return s
+}
+
+// compiled return the compiled form of the schema for the default validator, it's
+// compiled on first use and again when formats are registered afterwards
+func (s Schema) compiled() *Compiled {
+	if s.cache == nil {
+		return defaultValidator.Compile(s)
+	}
+
+	version := atomic.LoadUint64(&formatsVersion)
+	if c, ok := s.cache.Load().(*cachedCompile); ok && c.version == version {
+		return c.compiled
+	}
+
+	compiled := defaultValidator.Compile(s)
+	s.cache.Store(&cachedCompile{version: version, compiled: compiled})
+	return compiled
+}
+
+// fail record a misuse of a builder method, the schema is returned unchanged
+func (s Schema) fail(keyword string, format string, args ...interface{}) Schema {
+	err := &SchemaError{Location: "/" + keyword, Message: fmt.Sprintf(format, args...)}
+	s.errs = append(s.errs[:len(s.errs):len(s.errs)], err)
+	return s.renew()
+}
+
+func (s Schema) isNumeric() bool {
+	return s.data.Type == "integer" || s.data.Type == "number"
 }
 
 // Default set default value
-func (s *Schema) Default(value interface{}) *Schema {
-	value, err := castIfNumeric(value, &s.data)
+// records an error if the value can't be converted to the type of the schema
+func (s Schema) Default(value interface{}) Schema {
+	value, err := castIfNumeric(value, s.data.rkind)
 	if err != nil {
-		panic("invalid default value for type")
+		return s.fail("default", "invalid default value for type: %s", err)
 	}
 	s.data.Default = value
-	return s
+	return s.renew()
 }
 
 // Maximum set the maximum allowed value
-// panics if the type of the schema is not integer (int32, int64) or float (float 32, float64)
-func (s *Schema) Maximum(max float64) *Schema {
-	if s.data.Type != "integer" && s.data.Type != "number" {
-		panic("Maximum must only be used with integer and number types")
+// records an error if the type of the schema is not integer or number
+func (s Schema) Maximum(max float64) Schema {
+	if !s.isNumeric() {
+		return s.fail("maximum", "Maximum must only be used with integer and number types")
+	}
+	if s.data.Minimum != nil && *s.data.Minimum > max {
+		return s.fail("maximum", "maximum %v is less than minimum %v", max, *s.data.Minimum)
 	}
 	s.data.Maximum = &max
-	return s
+	return s.renew()
 }
 
 // Minimum set the minimum allowed value
-// panics if the type of the schema is not integer (int32, int64) or float (float 32, float64)
-func (s *Schema) Minimum(min float64) *Schema {
-	if s.data.Type != "integer" && s.data.Type != "number" {
-		panic("Minimum can be used only with integer and number types")
+// records an error if the type of the schema is not integer or number
+func (s Schema) Minimum(min float64) Schema {
+	if !s.isNumeric() {
+		return s.fail("minimum", "Minimum can be used only with integer and number types")
+	}
+	if s.data.Maximum != nil && *s.data.Maximum < min {
+		return s.fail("minimum", "minimum %v is greater than maximum %v", min, *s.data.Maximum)
 	}
 	s.data.Minimum = &min
-	return s
+	return s.renew()
 }
 
 // ExclusiveMaximum set the exclusive maximum allowed value
-// panics if the type of the schema is not integer (int32, int64) or float (float 32, float64)
-func (s *Schema) ExclusiveMaximum(max float64) *Schema {
-	if s.data.Type != "integer" && s.data.Type != "number" {
-		panic("ExclusiveMaximum must only be used with integer and number types")
+// records an error if the type of the schema is not integer or number
+func (s Schema) ExclusiveMaximum(max float64) Schema {
+	if !s.isNumeric() {
+		return s.fail("exclusiveMaximum", "ExclusiveMaximum must only be used with integer and number types")
+	}
+	if s.data.ExclusiveMinimum != nil && *s.data.ExclusiveMinimum >= max {
+		return s.fail("exclusiveMaximum", "exclusiveMaximum %v is not greater than exclusiveMinimum %v", max, *s.data.ExclusiveMinimum)
 	}
 	s.data.ExclusiveMaximum = &max
-	return s
+	return s.renew()
 }
 
 // ExclusiveMinimum set the exclusive minimum allowed value
-// panics if the type of the schema is not integer (int32, int64) or float (float 32, float64)
-func (s *Schema) ExclusiveMinimum(min float64) *Schema {
-	if s.data.Type != "integer" && s.data.Type != "number" {
-		panic("ExclusiveMinimum can be used only with integer and number types")
+// records an error if the type of the schema is not integer or number
+func (s Schema) ExclusiveMinimum(min float64) Schema {
+	if !s.isNumeric() {
+		return s.fail("exclusiveMinimum", "ExclusiveMinimum can be used only with integer and number types")
+	}
+	if s.data.ExclusiveMaximum != nil && *s.data.ExclusiveMaximum <= min {
+		return s.fail("exclusiveMinimum", "exclusiveMinimum %v is not less than exclusiveMaximum %v", min, *s.data.ExclusiveMaximum)
 	}
 	s.data.ExclusiveMinimum = &min
-	return s
+	return s.renew()
 }
 
 // MultipleOf set multipleOf
-// panics if the type of the schema is not integer (int32, int64)
-func (s *Schema) MultipleOf(value int64) *Schema {
+// records an error if the type of the schema is not integer or the value is not positive
+func (s Schema) MultipleOf(value int64) Schema {
 	if s.data.Type != "integer" {
-		panic("MultipleOf can be used only with integer type")
+		return s.fail("multipleOf", "MultipleOf can be used only with integer type")
+	}
+	if value <= 0 {
+		return s.fail("multipleOf", "multipleOf must be greater than 0")
 	}
 	s.data.MultipleOf = &value
-	return s
+	return s.renew()
 }
 
 // MaxLength set the maximum length of the string
-// panics if the type of the schema is not string
-func (s *Schema) MaxLength(max int) *Schema {
+// records an error if the type of the schema is not string or the length is invalid
+func (s Schema) MaxLength(max int) Schema {
 	if s.data.Type != "string" {
-		panic("MaxLength can be used only with string Schema")
+		return s.fail("maxLength", "MaxLength can be used only with string Schema")
+	}
+	if err := checkMax("maxLength", max, "minLength", s.data.MinLength); err != "" {
+		return s.fail("maxLength", "%s", err)
 	}
 	s.data.MaxLength = &max
-	return s
+	return s.renew()
 }
 
 // MinLength set the minimum length of the string
-// panics if the type of the schema is not string
-func (s *Schema) MinLength(min int) *Schema {
+// records an error if the type of the schema is not string or the length is invalid
+func (s Schema) MinLength(min int) Schema {
 	if s.data.Type != "string" {
-		panic("MinLength can be used only with string Schema")
+		return s.fail("minLength", "MinLength can be used only with string Schema")
+	}
+	if err := checkMin("minLength", min, "maxLength", s.data.MaxLength); err != "" {
+		return s.fail("minLength", "%s", err)
 	}
 	s.data.MinLength = &min
-	return s
+	return s.renew()
+}
+
+// Pattern set the regular expression (ECMA-262) the string must match
+// records an error if the type of the schema is not string or the expression is invalid
+func (s Schema) Pattern(expr string) Schema {
+	if s.data.Type != "string" {
+		return s.fail("pattern", "Pattern can be used only with string Schema")
+	}
+	re, err := compilePattern(expr)
+	if err != nil {
+		return s.fail("pattern", "invalid pattern: %s", err)
+	}
+	s.data.Pattern = expr
+	s.data.pattern = re
+	return s.renew()
+}
+
+// Format set the format of the string e.g. email, ipv4, date-time
+// records an error if the type of the schema is not string
+func (s Schema) Format(name string) Schema {
+	if s.data.Type != "string" {
+		return s.fail("format", "Format can be used only with string Schema")
+	}
+	s.data.Format = name
+	return s.renew()
 }
 
 // MaxItems set the maximum number of theitems in the array
-// panics if the type of the schema is not array or slice
-func (s *Schema) MaxItems(max int) *Schema {
+// records an error if the type of the schema is not array or slice or the number is invalid
+func (s Schema) MaxItems(max int) Schema {
 	if s.data.Type != "array" {
-		panic("MaxItems can be used only with array Schema")
+		return s.fail("maxItems", "MaxItems can be used only with array Schema")
+	}
+	if err := checkMax("maxItems", max, "minItems", s.data.MinItems); err != "" {
+		return s.fail("maxItems", "%s", err)
 	}
 	s.data.MaxItems = &max
-	return s
+	return s.renew()
 }
 
 // MinItems set the minimum number of the items in the array
-// panics if the type of the schema is not array or slice
-func (s *Schema) MinItems(min int) *Schema {
+// records an error if the type of the schema is not array or slice or the number is invalid
+func (s Schema) MinItems(min int) Schema {
 	if s.data.Type != "array" {
-		panic("MinItems can be used only with array Schema")
+		return s.fail("minItems", "MinItems can be used only with array Schema")
+	}
+	if err := checkMin("minItems", min, "maxItems", s.data.MaxItems); err != "" {
+		return s.fail("minItems", "%s", err)
 	}
 	s.data.MinItems = &min
-	return s
+	return s.renew()
 }
 
 // MaxProperties set the maximum number of the keys in the map
-// panics if the type of the schema is not map
-func (s *Schema) MaxProperties(max int) *Schema {
+// records an error if the type of the schema is not map or the number is invalid
+func (s Schema) MaxProperties(max int) Schema {
 	if s.data.Type != "object" {
-		panic("MaxProperties can be used only with object Schema")
+		return s.fail("maxProperties", "MaxProperties can be used only with object Schema")
+	}
+	if err := checkMax("maxProperties", max, "minProperties", s.data.MinProperties); err != "" {
+		return s.fail("maxProperties", "%s", err)
 	}
 	s.data.MaxProperties = &max
-	return s
+	return s.renew()
 }
 
 // MinProperties set the minimum number of the keys in the map
-// panics if the type of the schema is not map
-func (s *Schema) MinProperties(min int) *Schema {
+// records an error if the type of the schema is not map or the number is invalid
+func (s Schema) MinProperties(min int) Schema {
 	if s.data.Type != "object" {
-		panic("MinProperties can be used only with object Schema")
+		return s.fail("minProperties", "MinProperties can be used only with object Schema")
+	}
+	if err := checkMin("minProperties", min, "maxProperties", s.data.MaxProperties); err != "" {
+		return s.fail("minProperties", "%s", err)
 	}
 	s.data.MinProperties = &min
-	return s
+	return s.renew()
 }
 
-// Validate validate schema
-func (s *Schema) Validate(value interface{}) (interface{}, error) {
-	var err error
-
-	value, err = castIfNumeric(value, &s.data)
-	if err != nil {
-		return nil, err
+// Required set the names of the properties that must be present
+// records an error if the type of the schema is not object
+func (s Schema) Required(names ...string) Schema {
+	if s.data.Type != "object" {
+		return s.fail("required", "Required can be used only with object Schema")
 	}
-
-	v := reflect.ValueOf(value)
-	val, err := validate(v, &s.data)
-	if err != nil {
-		return nil, err
+	required := append([]string(nil), s.data.Required...)
+	for _, name := range names {
+		if !contains(required, name) {
+			required = append(required, name)
+		}
 	}
+	s.data.Required = required
+	return s.renew()
+}
 
-	if val.Kind() == reflect.Invalid {
-		return nil, nil
+// Enum set the allowed values
+// records an error if a value can't be converted to the type of the schema
+func (s Schema) Enum(values ...interface{}) Schema {
+	enum := make([]interface{}, len(values))
+	for i, value := range values {
+		v, err := castIfNumeric(value, s.data.rkind)
+		if err != nil {
+			return s.fail("enum/"+strconv.Itoa(i), "invalid enum value for type: %s", err)
+		}
+		enum[i] = v
 	}
+	s.data.Enum = enum
+	return s.renew()
+}
+
+// Validate validate value against the schema using the default validator
+// all the violations are collected and returned together as Errors
+// the schema is compiled on the first validation and the compiled form is reused
+func (s Schema) Validate(value interface{}) (interface{}, error) {
+	return s.compiled().Validate(value)
+}
+
+// ValidateJSON decode the json document and validate it using the default validator
+// the errors hold the position of the invalid values in the document
+func (s Schema) ValidateJSON(data []byte) (interface{}, error) {
+	return s.compiled().ValidateJSON(data)
+}
+
+// ValidateReader read the json document from r and validate it using the default validator
+func (s Schema) ValidateReader(r io.Reader) (interface{}, error) {
+	return s.compiled().ValidateReader(r)
+}
 
-	return val.Interface(), nil
+// Compile compile the schema using the default validator, the result is immutable
+// and can be shared to validate many values without walking the schema again
+func (s Schema) Compile() *Compiled {
+	return s.compiled()
 }
 
 // String string Schema
@@ -228,29 +415,67 @@ func String() Schema {
 			Type:  "string",
 			rkind: reflect.String,
 		},
-	}
+	}.renew()
+}
+
+// Int int Schema
+func Int() Schema {
+	return integer(reflect.Int)
+}
+
+// Int8 int8 Schema
+func Int8() Schema {
+	return integer(reflect.Int8)
+}
+
+// Int16 int16 Schema
+func Int16() Schema {
+	return integer(reflect.Int16)
 }
 
 // Int32 int32 Schema
 func Int32() Schema {
-	return Schema{
-		data: schema{
-			Type:   "integer",
-			Format: "int32",
-			rkind:  reflect.Int32,
-		},
-	}
+	return integer(reflect.Int32)
 }
 
 // Int64 int64 Schema
 func Int64() Schema {
+	return integer(reflect.Int64)
+}
+
+// Uint uint Schema
+func Uint() Schema {
+	return integer(reflect.Uint)
+}
+
+// Uint8 uint8 Schema
+func Uint8() Schema {
+	return integer(reflect.Uint8)
+}
+
+// Uint16 uint16 Schema
+func Uint16() Schema {
+	return integer(reflect.Uint16)
+}
+
+// Uint32 uint32 Schema
+func Uint32() Schema {
+	return integer(reflect.Uint32)
+}
+
+// Uint64 uint64 Schema
+func Uint64() Schema {
+	return integer(reflect.Uint64)
+}
+
+func integer(kind reflect.Kind) Schema {
 	return Schema{
 		data: schema{
 			Type:   "integer",
-			Format: "int64",
-			rkind:  reflect.Int64,
+			Format: formats[kind],
+			rkind:  kind,
 		},
-	}
+	}.renew()
 }
 
 // Float32 float32 Schema
@@ -261,7 +486,7 @@ func Float32() Schema {
 			Format: "float",
 			rkind:  reflect.Float32,
 		},
-	}
+	}.renew()
 }
 
 // Float64 float64 Schema
@@ -272,7 +497,7 @@ func Float64() Schema {
 			Format: "double",
 			rkind:  reflect.Float64,
 		},
-	}
+	}.renew()
 }
 
 // Boolean boolean Schema
@@ -282,13 +507,90 @@ func Boolean() Schema {
 			Type:  "boolean",
 			rkind: reflect.Bool,
 		},
-	}
+	}.renew()
 }
 
 // TypeOf get schema for an interface
-func TypeOf(i interface{}) Schema {
-	t := reflect.TypeOf(i)
+// the constraints of the fields are read from their `gskma` tag, e.g. `gskma:"minLength=2,required"`
+// and the names from their json tag, constraints that can't be applied are skipped, see TypeOfE
+// constraints still written in the json tag are ignored, see LegacyTags
+func TypeOf(i interface{}, opts ...TypeOption) Schema {
+	b := newBuilder(opts...)
 	return Schema{
-		data: *newSchema(t),
+		data: *b.build(reflect.TypeOf(i)),
+		root: b.root,
+		errs: b.hookErrs,
+	}.renew()
+}
+
+// TypeOfE get schema for an interface like TypeOf, every tag constraint that is unknown,
+// malformed or doesn't fit the kind of its field is reported as a *TagError in Errors
+// followed by the misuses of the builder methods in the schemas returned by JSONSchema
+func TypeOfE(i interface{}, opts ...TypeOption) (Schema, error) {
+	b := newBuilder(opts...)
+	s := Schema{
+		data: *b.build(reflect.TypeOf(i)),
+		root: b.root,
+	}
+
+	if errs := append(b.errs, b.hookErrs...); len(errs) > 0 {
+		return Schema{}, errs
+	}
+	return s.renew(), nil
+}
+
+// TypeOption change how TypeOf builds the schema of a type
+type TypeOption func(*builder)
+
+// TagName read the constraints of the fields from the tag with the given name instead of `gskma`
+func TagName(name string) TypeOption {
+	return func(b *builder) {
+		b.tag = name
+	}
+}
+
+// LegacyTags accept the constraints written in the json tag, e.g. `json:"name,minlen=2"`,
+// while migrating to the gskma tag, warn is called once per field that still uses them
+// and defaults to log.Print
+func LegacyTags(warn func(string)) TypeOption {
+	return func(b *builder) {
+		b.legacy = true
+		b.warn = warn
+		if b.warn == nil {
+			b.warn = func(msg string) { log.Print(msg) }
+		}
 	}
 }
+
+// AllOf Schema that matches values valid against all the given schemas
+func AllOf(schemas ...Schema) Schema {
+	list, errs := schemaList("allOf", schemas)
+	return Schema{
+		data: schema{
+			AllOf: list,
+		},
+		errs: errs,
+	}.renew()
+}
+
+// AnyOf Schema that matches values valid against at least one of the given schemas
+func AnyOf(schemas ...Schema) Schema {
+	list, errs := schemaList("anyOf", schemas)
+	return Schema{
+		data: schema{
+			AnyOf: list,
+		},
+		errs: errs,
+	}.renew()
+}
+
+// OneOf Schema that matches values valid against exactly one of the given schemas
+func OneOf(schemas ...Schema) Schema {
+	list, errs := schemaList("oneOf", schemas)
+	return Schema{
+		data: schema{
+			OneOf: list,
+		},
+		errs: errs,
+	}.renew()
+}
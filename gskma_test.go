@@ -1,13 +1,25 @@
 package gskma
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type Test struct {
-	A string `json:"a,required,maxlen=10,minlen=1"`
-	B int32  `json:"b,max=3,min=1"`
+	A string `json:"a" gskma:"required,maxlen=10,minlen=1"`
+	B int32  `json:"b" gskma:"max=3,min=1"`
 	C int64
 	D float32
 	E float64
@@ -287,9 +299,88 @@ func TestBasicTypes(t *testing.T) {
 	}
 }
 
+func TestIntegerKinds(t *testing.T) {
+	type testCase struct {
+		schema   Schema
+		value    interface{}
+		expected interface{}
+		err      string
+	}
+
+	cases := []testCase{
+		{schema: Int(), value: "42", expected: int(42)},
+		{schema: Int8(), value: int64(-128), expected: int8(-128)},
+		{schema: Int8(), value: 128, err: "value 128 overflows int8"},
+		{schema: Int16(), value: "40000", err: "value 40000 overflows int16"},
+		{schema: Int32(), value: int64(1) << 40, err: "overflows int32"},
+		{schema: Int64(), value: uint64(1) << 63, err: "overflows int64"},
+		{schema: Uint(), value: 7, expected: uint(7)},
+		{schema: Uint8(), value: 255.0, expected: uint8(255)},
+		{schema: Uint8(), value: 256.0, err: "value 256 overflows uint8"},
+		{schema: Uint16(), value: "70000", err: "value 70000 overflows uint16"},
+		{schema: Uint16(), value: json.Number("8080"), expected: uint16(8080)},
+		{schema: Uint16(), value: "-1", err: "value -1 overflows uint16"},
+		{schema: Uint32(), value: -1, err: "value -1 overflows uint32"},
+		{schema: Uint32(), value: "4294967295", expected: uint32(4294967295)},
+		{schema: Uint64(), value: "18446744073709551615", expected: uint64(18446744073709551615)},
+		{schema: Uint64(), value: 1.5, err: "value is not of type integer"},
+		{schema: Float64(), value: uint16(3), expected: float64(3)},
+		{schema: Uint16().Maximum(1024), value: uint16(8080), err: "less than or equal 1024"},
+		{schema: Uint32().MultipleOf(4), value: uint32(6), err: "divisible by 4"},
+		{schema: Int8().Minimum(-1.5), value: int8(-2), err: "greater than or equal -1.5"},
+		{schema: Int8().Minimum(-1.5), value: int8(-1), expected: int8(-1)},
+		{schema: Int64().Maximum(1 << 53), value: int64(1<<53 + 1), err: "less than or equal"},
+		{schema: Uint64().ExclusiveMaximum(1 << 63), value: uint64(1<<63 + 1), err: "less than"},
+	}
+
+	for _, c := range cases {
+		val, err := c.schema.Validate(c.value)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%v %T: expected error %q, got %v", c.value, c.value, c.err, err)
+			}
+			continue
+		}
+
+		if err != nil || val != c.expected {
+			t.Errorf("%v %T: expected %v %T, got %v %T (%v)", c.value, c.value, c.expected, c.expected, val, val, err)
+		}
+	}
+
+	type server struct {
+		Port    uint16 `json:"port" gskma:"min=1024"`
+		Workers int    `json:"workers" gskma:"min=1,max=64"`
+		Retries uint8  `json:"retries"`
+	}
+
+	s := TypeOf(server{})
+	if _, err := s.Validate(server{Port: 80, Workers: 100}); err == nil || len(err.(Errors)) != 2 {
+		t.Errorf("numeric keywords are not checked for uint16 and int fields: %v", err)
+	}
+
+	_, err := s.ValidateJSON([]byte(`{"port": 70000, "workers": 2, "retries": 3}`))
+	if err == nil || !strings.Contains(err.Error(), "/port (line 1, column 10): value 70000 overflows uint16") {
+		t.Errorf("overflows are not reported: %v", err)
+	}
+
+	var bound server
+	if err := Bind([]byte(`{"port": "8080", "workers": 2, "retries": 3}`), &bound); err != nil || bound.Port != 8080 {
+		t.Errorf("Bind is not working with unsigned fields: %v %v", bound, err)
+	}
+
+	var doc Schema
+	if err := json.Unmarshal([]byte(`{"type": "integer", "format": "uint16"}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := doc.Validate(70000); err == nil {
+		t.Error("integer formats are not used to decode the kind of the schema")
+	}
+}
+
 func TestDefault(t *testing.T) {
 	s := String()
-	s.Default("test")
+	s = s.Default("test")
 
 	v, _ := s.Validate(nil)
 	if v != "test" {
@@ -297,7 +388,7 @@ func TestDefault(t *testing.T) {
 	}
 
 	s = Int32()
-	s.Default(1)
+	s = s.Default(1)
 
 	v, _ = s.Validate(nil)
 	if v.(int32) != 1 {
@@ -305,7 +396,7 @@ func TestDefault(t *testing.T) {
 	}
 
 	s = Int64()
-	s.Default(1)
+	s = s.Default(1)
 
 	v, _ = s.Validate(nil)
 	if v.(int64) != 1 {
@@ -313,7 +404,7 @@ func TestDefault(t *testing.T) {
 	}
 
 	s = Float32()
-	s.Default(1.5)
+	s = s.Default(1.5)
 
 	v, _ = s.Validate(nil)
 	if v.(float32) != 1.5 {
@@ -321,7 +412,7 @@ func TestDefault(t *testing.T) {
 	}
 
 	s = Float64()
-	s.Default(1.5)
+	s = s.Default(1.5)
 
 	v, _ = s.Validate(nil)
 	if v.(float64) != 1.5 {
@@ -329,7 +420,7 @@ func TestDefault(t *testing.T) {
 	}
 
 	s = Boolean()
-	s.Default(true)
+	s = s.Default(true)
 
 	v, _ = s.Validate(nil)
 	if v.(bool) != true {
@@ -340,7 +431,7 @@ func TestDefault(t *testing.T) {
 
 	s = TypeOf(map[string]string{})
 	d = map[string]string{"a": "a"}
-	s.Default(d)
+	s = s.Default(d)
 
 	v, _ = s.Validate(nil)
 	if !reflect.DeepEqual(v, d) {
@@ -349,7 +440,7 @@ func TestDefault(t *testing.T) {
 
 	s = TypeOf([]string{})
 	d = []string{"a"}
-	s.Default(d)
+	s = s.Default(d)
 
 	v, _ = s.Validate(nil)
 	if !reflect.DeepEqual(v, d) {
@@ -358,7 +449,7 @@ func TestDefault(t *testing.T) {
 
 	s = TypeOf(Test{})
 	d = Test{A: "string", B: 1, C: 1, D: 1.5, E: 1.5}
-	s.Default(d)
+	s = s.Default(d)
 
 	v, _ = s.Validate(nil)
 	if !reflect.DeepEqual(v, d) {
@@ -367,7 +458,7 @@ func TestDefault(t *testing.T) {
 
 	s = TypeOf([]Test{})
 	d = []Test{{A: "string", B: 1, C: 1, D: 1.5, E: 1.5}}
-	s.Default(d)
+	s = s.Default(d)
 
 	v, _ = s.Validate(nil)
 	if !reflect.DeepEqual(v, d) {
@@ -440,7 +531,7 @@ func TestMinimum(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		c.schema.Minimum(1)
+		c.schema = c.schema.Minimum(1)
 		_, err := c.schema.Validate(c.value)
 
 		if c.err != (err != nil) {
@@ -514,7 +605,7 @@ func TestMaximum(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		c.schema.Maximum(1)
+		c.schema = c.schema.Maximum(1)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("Maximum is not working for", c.schema.data.rkind)
@@ -587,7 +678,7 @@ func TestExclusiveMaximum(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		c.schema.ExclusiveMaximum(1)
+		c.schema = c.schema.ExclusiveMaximum(1)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("ExclusiveMaximum is not working for", c.schema.data.rkind)
@@ -659,7 +750,7 @@ func TestExclusiveMinimum(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		c.schema.ExclusiveMinimum(1)
+		c.schema = c.schema.ExclusiveMinimum(1)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("ExclusiveMinimum is not working for", c.schema.data.rkind)
@@ -701,7 +792,7 @@ func TestMultipleOf(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		c.schema.MultipleOf(2)
+		c.schema = c.schema.MultipleOf(2)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("TestMultipleOf is not working for type", c.schema.data.rkind)
@@ -728,7 +819,7 @@ func TestMaxLength(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		c.schema.MaxLength(1)
+		c.schema = c.schema.MaxLength(1)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("MaxLength is not working for", c.schema.data.rkind)
@@ -755,7 +846,7 @@ func TestMinLength(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		c.schema.MinLength(1)
+		c.schema = c.schema.MinLength(1)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("MinLength is not working for", c.schema.data.rkind)
@@ -782,7 +873,7 @@ func TestMaxItems(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		c.schema.MaxItems(1)
+		c.schema = c.schema.MaxItems(1)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("MaxItems is not working")
@@ -809,7 +900,7 @@ func TestMinItems(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		c.schema.MinItems(1)
+		c.schema = c.schema.MinItems(1)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("MinItems is not working")
@@ -836,7 +927,7 @@ func TestMaxProperties(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		c.schema.MaxProperties(1)
+		c.schema = c.schema.MaxProperties(1)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("MaxProperties is not working")
@@ -863,7 +954,7 @@ func TestMinProperties(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		c.schema.MinProperties(1)
+		c.schema = c.schema.MinProperties(1)
 		_, err := c.schema.Validate(c.value)
 		if c.err != (err != nil) {
 			t.Error("MinProperties is not working")
@@ -873,11 +964,11 @@ func TestMinProperties(t *testing.T) {
 
 func TestSchemaFromStructTag(t *testing.T) {
 	type test struct {
-		A string            `json:"a,maxlen=10,minlen=1"`
-		B int               `json:"b,max=3,min=1,multof=2"`
-		C int               `json:"c,exclmax=3,exclmin=1"`
-		D []string          `json:"d,maxItems=5,minItems=2"`
-		E map[string]string `json:"e,maxprops=6,minprops=3"`
+		A string            `json:"a" gskma:"maxlen=10,minlen=1"`
+		B int               `json:"b" gskma:"max=3,min=1,multof=2"`
+		C int               `json:"c" gskma:"exclmax=3,exclmin=1"`
+		D []string          `json:"d" gskma:"maxItems=5,minItems=2"`
+		E map[string]string `json:"e" gskma:"maxprops=6,minprops=3"`
 	}
 
 	s := TypeOf(test{})
@@ -926,3 +1017,2164 @@ func TestSchemaFromStructTag(t *testing.T) {
 		t.Error("minprops tag is not working")
 	}
 }
+
+func TestTagNamespace(t *testing.T) {
+	type test struct {
+		Name  string `json:"name" gskma:"minLength=2,required"`
+		Nick  string `json:"nick,omitempty" gskma:"minLength=3"`
+		Count int32  `json:"count,string" gskma:"maximum=3"`
+		Plain string `json:"plain,maxlen=1"`
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	s := TypeOf(test{})
+	log.SetOutput(os.Stderr)
+
+	if !contains(s.data.Required, "name") || *s.data.Properties["name"].MinLength != 2 {
+		t.Error("gskma tag is not working")
+	}
+
+	if logs.Len() != 0 {
+		t.Errorf("TypeOf must not log the ignored json tag constraints, got %q", logs.String())
+	}
+
+	_, err := TypeOfE(test{})
+	var terr *TagError
+	if !errors.As(err, &terr) || terr.Field != "Plain" || terr.Segment != "maxlen=1" {
+		t.Errorf("TypeOfE must report ignored json tag constraints, got %v", err)
+	}
+
+	if _, err := s.Validate(test{Name: "go", Count: 3, Plain: "legacy"}); err != nil {
+		t.Errorf("json options must not be read as constraints: %v", err)
+	}
+
+	if _, err := s.Validate(test{Name: "go", Nick: "ab"}); err == nil {
+		t.Error("omitempty must only skip zero values")
+	}
+
+	type zero struct {
+		Count int32 `json:"count,omitzero" gskma:"min=1"`
+	}
+
+	omitted, err := TypeOfE(zero{})
+	if err != nil {
+		t.Errorf("omitzero must not be read as a constraint: %v", err)
+	}
+
+	if _, err := omitted.Validate(zero{}); err != nil {
+		t.Errorf("omitzero must skip zero values: %v", err)
+	}
+
+	if _, err := omitted.Validate(zero{Count: -1}); err == nil {
+		t.Error("omitzero must only skip zero values")
+	}
+
+	type custom struct {
+		A int32 `json:"a" validate:"max=3"`
+	}
+
+	if _, err := TypeOf(custom{}, TagName("validate")).Validate(custom{A: 4}); err == nil {
+		t.Error("TagName is not working")
+	}
+
+	var warnings []string
+	legacy := TypeOf(test{}, LegacyTags(func(msg string) { warnings = append(warnings, msg) }))
+
+	if _, err := legacy.Validate(test{Name: "go", Plain: "legacy"}); err == nil {
+		t.Error("LegacyTags must accept the constraints of the json tag")
+	}
+
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `field gskma.test.Plain`) ||
+		!strings.Contains(warnings[0], `move "maxlen=1" to the gskma tag`) {
+		t.Errorf("LegacyTags must warn about the json tag constraints: %v", warnings)
+	}
+}
+
+type tagged struct {
+	A string            `json:"a" gskma:"maxlen=1O,minlen=1"`
+	B int32             `json:"b" gskma:"maxlenght=5"`
+	C string            `json:"c" gskma:"pattern"`
+	D int32             `json:"d" gskma:"maxlen=3"`
+	E []string          `json:"e" gskma:"required=true,minitems=-1"`
+	F int64             `json:"f" gskma:"default=x,enum=1|y"`
+	G map[string]string `json:"g" gskma:"format=email,pattern=(,maxprops=2"`
+}
+
+func TestTypeOfE(t *testing.T) {
+	_, err := TypeOfE(tagged{})
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("TypeOfE must return Errors, got %v", err)
+	}
+
+	expected := []string{
+		"A maxlen=1O",
+		"B maxlenght=5",
+		"C pattern",
+		"D maxlen=3",
+		"E required=true",
+		"E minitems=-1",
+		"F default=x",
+		"F enum=1|y",
+		"G format=email",
+		"G pattern=(",
+	}
+
+	var found []string
+	for _, e := range errs {
+		var terr *TagError
+		if !errors.As(e, &terr) || terr.Type != "gskma.tagged" {
+			t.Errorf("unexpected error %v", e)
+			continue
+		}
+		found = append(found, terr.Field+" "+terr.Segment)
+	}
+
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("TypeOfE is not reporting every bad tag, expected %v, got %v", expected, found)
+	}
+
+	if !strings.Contains(err.Error(), `gskma.tagged.D: "maxlen=3": maxLength can be used only with string fields, not int32`) {
+		t.Errorf("unexpected error message: %v", err)
+	}
+
+	s := TypeOf(tagged{})
+	if *s.data.Properties["a"].MinLength != 1 || *s.data.Properties["g"].MaxProperties != 2 {
+		t.Error("TypeOf must apply the valid constraints and skip the bad ones")
+	}
+
+	if _, err := TypeOfE(Test{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type embeddedBase struct {
+	ID     string `json:"id" gskma:"required"`
+	Name   string `json:"name"`
+	Kind   string `json:"kind" gskma:"default=user"`
+	secret string
+}
+
+type embeddedAudit struct {
+	Name string `json:"name"`
+	By   string `json:"by" gskma:"minlen=2"`
+}
+
+type embeddedOuter struct {
+	embeddedBase
+	*embeddedAudit
+	Title  string `json:"title"`
+	Skip   string `json:"-"`
+	Dash   string `json:"-,"`
+	hidden int
+}
+
+type embeddedShadow struct {
+	embeddedBase
+	ID int32 `json:"id"`
+}
+
+func TestEmbeddedFields(t *testing.T) {
+	value := embeddedOuter{
+		embeddedBase:  embeddedBase{ID: "1", Name: "a", Kind: "admin", secret: "s"},
+		embeddedAudit: &embeddedAudit{Name: "b", By: "me"},
+		Title:         "t",
+		Skip:          "s",
+		Dash:          "d",
+		hidden:        1,
+	}
+
+	data, _ := json.Marshal(value)
+	var encoded map[string]interface{}
+	json.Unmarshal(data, &encoded)
+
+	s := TypeOf(embeddedOuter{})
+	if len(s.data.Properties) != len(encoded) {
+		t.Errorf("TypeOf must discover the fields encoding/json encodes, expected %v, got %v", encoded, s.data.Properties)
+	}
+	for name := range encoded {
+		if _, ok := s.data.Properties[name]; !ok {
+			t.Errorf("field %s is not discovered", name)
+		}
+	}
+
+	if !reflect.DeepEqual(s.data.Required, []string{"id"}) {
+		t.Errorf("required promoted field is not working, got %v", s.data.Required)
+	}
+
+	if TypeOf(embeddedShadow{}).data.Properties["id"].Type != "integer" {
+		t.Error("shallower field must hide the embedded one")
+	}
+
+	testcases := []struct {
+		value embeddedOuter
+		errs  []string
+	}{
+		{value: value},
+		{value: embeddedOuter{}, errs: []string{"field id is required"}},
+		{value: embeddedOuter{embeddedBase: embeddedBase{ID: "1"}, embeddedAudit: &embeddedAudit{By: "x"}}, errs: []string{"/by: value length must be at least 2 character(s)"}},
+	}
+
+	for _, tc := range testcases {
+		_, err := s.Validate(tc.value)
+
+		var found []string
+		var errs Errors
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				found = append(found, e.Error())
+			}
+		}
+
+		if !reflect.DeepEqual(found, tc.errs) {
+			t.Errorf("embedded fields are not working, expected %v, got %v", tc.errs, found)
+		}
+	}
+
+	out, err := NewValidator().ApplyDefaults(true).Validate(s, embeddedOuter{embeddedBase: embeddedBase{ID: "1"}})
+	if err != nil || out.(embeddedOuter).Kind != "user" {
+		t.Errorf("defaults of promoted fields are not working, got %v %v", out, err)
+	}
+}
+
+type wellKnownTypes struct {
+	Created time.Time       `json:"created"`
+	Timeout time.Duration   `json:"timeout"`
+	Extra   json.RawMessage `json:"extra"`
+	Addr    net.IP          `json:"addr"`
+	Home    *url.URL        `json:"home"`
+	Total   *big.Int        `json:"total"`
+}
+
+func TestWellKnownTypes(t *testing.T) {
+	s := TypeOf(wellKnownTypes{})
+
+	data, _ := json.Marshal(s)
+	expected := `{"title":"wellKnownTypes","type":"object","properties":{` +
+		`"addr":{"title":"addr","type":"string","anyOf":[{"format":"ipv4"},{"format":"ipv6"}]},` +
+		`"created":{"title":"created","type":"string","format":"date-time"},` +
+		`"extra":{"title":"extra"},` +
+		`"home":{"title":"home","type":"string","format":"uri"},` +
+		`"timeout":{"title":"timeout","type":"integer","format":"int64"},` +
+		`"total":{"title":"total","type":"integer"}}}`
+	if string(data) != expected {
+		t.Errorf("schemas of well known types are not working, expected %s, got %s", expected, data)
+	}
+
+	home, _ := url.Parse("https://example.com")
+	value := wellKnownTypes{
+		Created: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Timeout: time.Second,
+		Extra:   json.RawMessage(`{"a": 1}`),
+		Addr:    net.ParseIP("10.0.0.1"),
+		Home:    home,
+		Total:   big.NewInt(-42),
+	}
+
+	out, err := NewValidator().ApplyDefaults(true).Validate(s, value)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(out, value) {
+		t.Errorf("values of well known types must be kept, got %v", out)
+	}
+
+	invalid := value
+	invalid.Extra = json.RawMessage(`{`)
+	invalid.Addr = net.IP{1, 2, 3}
+	invalid.Home = &url.URL{Path: "/relative"}
+
+	_, err = s.Validate(invalid)
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Errorf("invalid values of well known types must be reported, got %v", err)
+	}
+
+	testcases := []struct {
+		value string
+		valid bool
+	}{
+		{value: `{"created": "2021-03-04T05:06:07Z", "timeout": 1000, "total": 123456789012345678901234567890}`, valid: true},
+		{value: `{"addr": "::1", "home": "urn:isbn:0451450523"}`, valid: true},
+		{value: `{"timeout": "PT1H"}`},
+		{value: `{"created": "yesterday"}`},
+		{value: `{"timeout": true}`},
+		{value: `{"timeout": "1h"}`},
+		{value: `{"addr": "10.0.0"}`},
+		{value: `{"total": "5"}`},
+		{value: `{"total": 1.5}`},
+	}
+
+	for _, tc := range testcases {
+		if _, err := s.ValidateJSON([]byte(tc.value)); (err == nil) != tc.valid {
+			t.Errorf("well known types are not working for %s, got %v", tc.value, err)
+		}
+	}
+
+	var bound wellKnownTypes
+	if err := Bind([]byte(`{"timeout": 1000000000}`), &bound); err != nil || bound.Timeout != time.Second {
+		t.Errorf("Bind is not working with time.Duration, got %v %v", bound.Timeout, err)
+	}
+
+	if err := Bind([]byte(`{"timeout": "PT1S"}`), &bound); !errors.As(err, &errs) {
+		t.Errorf("durations that can't be decoded must fail validation, got %v", err)
+	}
+}
+
+type hookCode string
+
+func (hookCode) JSONSchema() Schema {
+	return String().Pattern(`^[A-Z]{3}$`)
+}
+
+type hookPeriod struct {
+	Start time.Time `json:"start" gskma:"required"`
+	End   time.Time `json:"end" gskma:"required"`
+}
+
+func (p *hookPeriod) Validate() error {
+	if !p.End.After(p.Start) {
+		return errors.New("end must be after start")
+	}
+	return nil
+}
+
+type hookBooking struct {
+	Code   hookCode   `json:"code"`
+	Period hookPeriod `json:"period"`
+}
+
+type hookBroken struct{}
+
+func (hookBroken) JSONSchema() Schema {
+	return String().MinLength(-1)
+}
+
+type hookTree struct{}
+
+func (hookTree) JSONSchema() Schema {
+	return TypeOf(node{}).Required("name")
+}
+
+type hookForest struct {
+	Main  hookTree   `json:"main"`
+	Other []hookTree `json:"other"`
+	Leaf  leaf       `json:"leaf"`
+}
+
+type bigCounter struct {
+	N *big.Int `json:"n" gskma:"required,min=1,multof=3"`
+}
+
+type optionalKnown struct {
+	Name string  `json:"name"`
+	IP   net.IP  `json:"ip"`
+	U    url.URL `json:"u"`
+	Home url.URL `json:"home" gskma:"required"`
+}
+
+func TestZeroKnownTypes(t *testing.T) {
+	s := TypeOf(optionalKnown{})
+
+	home, _ := url.Parse("https://example.com")
+	if _, err := s.Validate(optionalKnown{Name: "x", Home: *home}); err != nil {
+		t.Errorf("zero values of well known types must be absent, got %v", err)
+	}
+
+	_, err := s.Validate(optionalKnown{Name: "x", IP: net.IP{}})
+
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].(*ValidationError).Keyword != "required" {
+		t.Errorf("required zero value of well known type must be reported, got %v", err)
+	}
+}
+
+func TestBigInt(t *testing.T) {
+	n, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	value := bigCounter{N: n}
+
+	data, _ := json.Marshal(value)
+	if _, err := TypeOf(value).ValidateJSON(data); err != nil {
+		t.Errorf("encoding of big.Int must be valid, got %v", err)
+	}
+
+	if _, err := TypeOf(value).Validate(value); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	var bound bigCounter
+	if err := Bind(data, &bound); err != nil || bound.N.Cmp(n) != 0 {
+		t.Errorf("Bind is not working with big.Int, got %v %v", bound.N, err)
+	}
+
+	testcases := []struct {
+		value   string
+		keyword string
+	}{
+		{value: `{"n": "5"}`, keyword: "type"},
+		{value: `{"n": 1.5}`, keyword: "type"},
+		{value: `{"n": -123456789012345678901234567890}`, keyword: "minimum"},
+		{value: `{"n": 123456789012345678901234567891}`, keyword: "multipleOf"},
+	}
+
+	for _, tc := range testcases {
+		err := Bind([]byte(tc.value), &bound)
+
+		var errs Errors
+		if !errors.As(err, &errs) || len(errs) != 1 || errs[0].(*ValidationError).Keyword != tc.keyword {
+			t.Errorf("big.Int is not working for %s, expected %s error, got %v", tc.value, tc.keyword, err)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	s := TypeOf(hookBooking{})
+	if s.data.Properties["code"].Pattern != `^[A-Z]{3}$` {
+		t.Error("JSONSchema hook is not working")
+	}
+
+	if TypeOf(hookBroken{}).Err() == nil {
+		t.Error("errors of the schema returned by JSONSchema must be reported")
+	}
+
+	var serr *SchemaError
+	if _, err := TypeOfE(hookBroken{}); !errors.As(err, &serr) || serr.Location != "/minLength" {
+		t.Errorf("TypeOfE must report the errors of the schema returned by JSONSchema, got %v", err)
+	}
+
+	forest := TypeOf(hookForest{})
+	out, err := json.Marshal(forest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var loaded Schema
+	if err := json.Unmarshal(out, &loaded); err != nil {
+		t.Fatalf("the definitions of the schema returned by JSONSchema must be moved to the document: %v", err)
+	}
+
+	doc := map[string]interface{}{
+		"main":  map[string]interface{}{"name": "a", "children": []interface{}{map[string]interface{}{}}},
+		"other": []interface{}{map[string]interface{}{"name": "b", "leaf": map[string]interface{}{"value": 11}}},
+		"leaf":  map[string]interface{}{"value": 1},
+	}
+
+	for _, s := range []Schema{forest, loaded} {
+		_, err := s.Validate(doc)
+		if fmt.Sprint(err) != "/main/children/0: field name is required; /other/0/leaf/value: value must be less than or equal 10" {
+			t.Errorf("schemas returned by JSONSchema with definitions are not working, got %v", err)
+		}
+	}
+
+	start := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	testcases := []struct {
+		value hookBooking
+		errs  []string
+	}{
+		{value: hookBooking{Code: "ABC", Period: hookPeriod{Start: start, End: end}}},
+		{value: hookBooking{Code: "abc", Period: hookPeriod{Start: start, End: end}}, errs: []string{"/code: value must match pattern ^[A-Z]{3}$"}},
+		{value: hookBooking{Code: "ABC", Period: hookPeriod{Start: end, End: start}}, errs: []string{"/period: end must be after start"}},
+		{value: hookBooking{Code: "ABC", Period: hookPeriod{End: start}}, errs: []string{"/period: field start is required"}},
+	}
+
+	for _, tc := range testcases {
+		_, err := s.Validate(tc.value)
+
+		var found []string
+		var errs Errors
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				found = append(found, e.Error())
+			}
+		}
+
+		if !reflect.DeepEqual(found, tc.errs) {
+			t.Errorf("Validate hook is not working, expected %v, got %v", tc.errs, found)
+		}
+	}
+}
+
+func TestCollectAllErrors(t *testing.T) {
+	type test struct {
+		A string   `json:"a" gskma:"maxlen=1"`
+		B int32    `json:"b" gskma:"max=3"`
+		C []string `json:"c" gskma:"maxitems=1"`
+	}
+
+	s := TypeOf(test{})
+	_, err := s.Validate(test{A: "aa", B: 4, C: []string{"a", "b"}})
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatal("Validate must return Errors")
+	}
+
+	if len(errs) != 3 {
+		t.Errorf("expected 3 errors, got %d: %v", len(errs), errs)
+	}
+
+	_, err = s.Validate(test{A: "a", B: 1})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	cases := []struct {
+		schema   Schema
+		location string
+	}{
+		{schema: String().Maximum(3), location: "/maximum"},
+		{schema: Int32().MaxLength(3), location: "/maxLength"},
+		{schema: Int32().Default("a"), location: "/default"},
+		{schema: Int32().Enum(1, "a"), location: "/enum/1"},
+		{schema: Int64().Minimum(5).Maximum(1), location: "/maximum"},
+		{schema: Int64().Maximum(1).Minimum(5), location: "/minimum"},
+		{schema: Float64().ExclusiveMinimum(1).ExclusiveMaximum(1), location: "/exclusiveMaximum"},
+		{schema: Int64().MultipleOf(0), location: "/multipleOf"},
+		{schema: String().MinLength(-1), location: "/minLength"},
+		{schema: String().MinLength(3).MaxLength(2), location: "/maxLength"},
+		{schema: TypeOf([]string{}).MaxItems(1).MinItems(2), location: "/minItems"},
+		{schema: TypeOf(map[string]string{}).MaxProperties(-1), location: "/maxProperties"},
+		{schema: TypeOf([]string{}).Required("a"), location: "/required"},
+		{schema: AnyOf(String(), Int32().Format("email")), location: "/anyOf/1/format"},
+	}
+
+	for _, c := range cases {
+		s, err := c.schema.Build()
+
+		var errs Errors
+		if !errors.As(err, &errs) || len(errs) != 1 {
+			t.Errorf("%s: expected a single error, got %v", c.location, err)
+			continue
+		}
+
+		var serr *SchemaError
+		if !errors.As(errs[0], &serr) || serr.Location != c.location {
+			t.Errorf("%s: unexpected error %v", c.location, errs[0])
+		}
+
+		if _, verr := s.Validate(nil); !reflect.DeepEqual(verr, err) {
+			t.Errorf("%s: Validate must report the errors of the builder, got %v", c.location, verr)
+		}
+	}
+
+	s := String().MaxLength(-1).Maximum(1).MinLength(1)
+	if err := s.Err(); err == nil || len(err.(Errors)) != 2 {
+		t.Errorf("builder errors are not accumulated: %v", err)
+	}
+
+	if _, err := s.Validate(""); err == nil {
+		t.Error("valid keywords must not hide the builder errors")
+	}
+
+	if _, err := String().MinLength(1).MaxLength(2).Build(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type lintInner struct {
+	Code string `json:"code" gskma:"minlen=10,maxlen=3"`
+}
+
+type lintOuter struct {
+	Count int64     `json:"count" gskma:"default=3,max=2"`
+	Inner lintInner `json:"inner"`
+}
+
+func TestLint(t *testing.T) {
+	var doc Schema
+	err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["a", "z"],
+		"additionalProperties": false,
+		"properties": {
+			"a": {"type": "integer", "minimum": 5, "exclusiveMaximum": 5},
+			"b": {"type": "string", "enum": ["x", "yy"], "maxLength": 1, "default": "yy"},
+			"c": {"type": "array", "items": {"type": "number", "exclusiveMinimum": 1, "maximum": 0.5}}
+		}
+	}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		schema    Schema
+		locations []string
+	}{
+		{
+			schema:    String().MinLength(1).MaxLength(3).Default("ab"),
+			locations: nil,
+		},
+		{
+			schema:    String().MaxLength(3).MinLength(10),
+			locations: []string{"/minLength"},
+		},
+		{
+			schema:    Int32().Maximum(3).Default(5),
+			locations: []string{"/default"},
+		},
+		{
+			schema: doc,
+			locations: []string{
+				"/required/1",
+				"/properties/a/minimum",
+				"/properties/b/enum/1",
+				"/properties/b/default",
+				"/properties/c/items/exclusiveMinimum",
+			},
+		},
+		{
+			schema: TypeOf(lintOuter{}),
+			locations: []string{
+				"/properties/count/default",
+				"/$defs/gskma.lintInner/properties/code/minLength",
+			},
+		},
+	}
+
+	for i, c := range cases {
+		findings := c.schema.Lint()
+
+		var locations []string
+		for _, f := range findings {
+			locations = append(locations, f.Location)
+		}
+
+		if !reflect.DeepEqual(locations, c.locations) {
+			t.Errorf("case %d: Lint is not working, expected %v, got %v", i, c.locations, findings)
+		}
+	}
+
+	findings := Int32().MaxLength(1).Lint()
+	if len(findings) != 1 || findings[0].Location != "/maxLength" || findings[0].Keyword != "maxLength" {
+		t.Errorf("builder errors must be reported by Lint: %v", findings)
+	}
+}
+
+func TestErrorsIsAs(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	errs := Errors{fmt.Errorf("first"), fmt.Errorf("wrapped: %w", sentinel)}
+
+	if !errors.Is(errs, sentinel) {
+		t.Error("errors.Is is not working with Errors")
+	}
+
+	if errors.Is(Errors{fmt.Errorf("first")}, sentinel) {
+		t.Error("errors.Is must not match unrelated errors")
+	}
+
+	if errs.Error() != "first; wrapped: sentinel" {
+		t.Errorf("unexpected error message: %s", errs.Error())
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	type address struct {
+		Zip string `json:"zip" gskma:"maxlen=5"`
+	}
+
+	type test struct {
+		Age     int32     `json:"age" gskma:"max=150"`
+		Address []address `json:"address"`
+	}
+
+	s := TypeOf(test{})
+	_, err := s.Validate(test{Age: 200, Address: []address{{}, {}, {Zip: "1234567"}}})
+
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+
+	expected := map[string]ValidationError{
+		"/age": {
+			InstanceLocation: "/age",
+			Keyword:          "maximum",
+			SchemaLocation:   "/properties/age/maximum",
+			Value:            int32(200),
+			Limit:            float64(150),
+		},
+		"/address/2/zip": {
+			InstanceLocation: "/address/2/zip",
+			Keyword:          "maxLength",
+			SchemaLocation:   "/properties/address/items/$ref/properties/zip/maxLength",
+			Value:            "1234567",
+			Limit:            5,
+		},
+	}
+
+	for _, e := range errs {
+		var verr *ValidationError
+		if !errors.As(e, &verr) {
+			t.Fatalf("expected ValidationError, got %T", e)
+		}
+
+		exp, ok := expected[verr.InstanceLocation]
+		if !ok {
+			t.Errorf("unexpected error at %s", verr.InstanceLocation)
+			continue
+		}
+
+		if verr.Keyword != exp.Keyword || verr.SchemaLocation != exp.SchemaLocation ||
+			verr.Value != exp.Value || verr.Limit != exp.Limit {
+			t.Errorf("unexpected error %#v", verr)
+		}
+	}
+
+	s = Int32()
+	_, err = s.Validate("abc")
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Keyword != "type" || verr.Value != "abc" {
+		t.Errorf("type error is not reported as ValidationError: %#v", err)
+	}
+}
+
+func TestPointerEscaping(t *testing.T) {
+	loc := location{}.child("a/b~c", "properties", "a/b~c")
+
+	if loc.instance != "/a~1b~0c" {
+		t.Errorf("instance location is not escaped: %s", loc.instance)
+	}
+
+	if loc.schema != "/properties/a~1b~0c" {
+		t.Errorf("schema location is not escaped: %s", loc.schema)
+	}
+}
+
+func TestComposition(t *testing.T) {
+	short := String()
+	short = short.MaxLength(3)
+
+	long := String()
+	long = long.MinLength(2)
+
+	cases := []struct {
+		schema Schema
+		value  interface{}
+		err    bool
+	}{
+		{schema: AllOf(short, long), value: "abc", err: false},
+		{schema: AllOf(short, long), value: "a", err: true},
+		{schema: AllOf(short, long), value: "abcd", err: true},
+		{schema: AnyOf(short, Int32()), value: "ab", err: false},
+		{schema: AnyOf(short, Int32()), value: 5, err: false},
+		{schema: AnyOf(short, Int32()), value: "abcd", err: true},
+		{schema: OneOf(short, long), value: "a", err: false},
+		{schema: OneOf(short, long), value: "abcd", err: false},
+		{schema: OneOf(short, long), value: "ab", err: true},
+		{schema: OneOf(short, Boolean()), value: 1.5, err: true},
+	}
+
+	for i, c := range cases {
+		_, err := c.schema.Validate(c.value)
+		if c.err != (err != nil) {
+			t.Errorf("Test Case #%d: unexpected error: %v", i, err)
+		}
+	}
+
+	s := OneOf(short, Boolean())
+	_, err := s.Validate("abcd")
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Keyword != "oneOf" || len(verr.Causes) != 2 {
+		t.Fatalf("oneOf must report the failures of each branch: %v", err)
+	}
+
+	var cause *ValidationError
+	if !errors.As(verr.Causes[0], &cause) || cause.SchemaLocation != "/oneOf/0/maxLength" {
+		t.Errorf("unexpected cause: %v", verr.Causes[0])
+	}
+
+	s = AnyOf(String(), Int32())
+	v, _ := s.Validate(int64(7))
+	if v != int32(7) {
+		t.Errorf("anyOf must return the value of the matched branch, got %#v", v)
+	}
+}
+
+type color string
+
+// palette has a Values method that is not an enum and panics on the zero value
+type palette struct {
+	colors *[]color
+}
+
+func (p palette) Values() []color { return *p.colors }
+
+type level int32
+
+func (level) Values() []int32 { return []int32{1, 2} }
+
+func (color) Values() []color {
+	return []color{"red", "green"}
+}
+
+func TestEnum(t *testing.T) {
+	s := String()
+	s = s.Enum("a", "b")
+
+	i := Int32()
+	i = i.Enum("1", 2)
+
+	cases := []OptionTestCase{
+		{schema: s, value: "a", err: false},
+		{schema: s, value: "c", err: true},
+		{schema: i, value: 1, err: false},
+		{schema: i, value: "2", err: false},
+		{schema: i, value: 3, err: true},
+	}
+
+	for _, c := range cases {
+		_, err := c.schema.Validate(c.value)
+		if c.err != (err != nil) {
+			t.Error("Enum is not working for", c.schema.data.rkind, c.value)
+		}
+	}
+
+	var typeless Schema
+	if err := json.Unmarshal([]byte(`{"enum": [1, 2.5, "a"]}`), &typeless); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	typelessCases := []struct {
+		value interface{}
+		err   bool
+	}{
+		{value: 1, err: false},
+		{value: int8(1), err: false},
+		{value: uint64(1), err: false},
+		{value: 1.0, err: false},
+		{value: 2.5, err: false},
+		{value: json.Number("1"), err: false},
+		{value: "a", err: false},
+		{value: "1", err: true},
+		{value: 2, err: true},
+	}
+
+	for _, c := range typelessCases {
+		_, err := typeless.Validate(c.value)
+		if c.err != (err != nil) {
+			t.Errorf("typeless Enum is not working for %T %v: %v", c.value, c.value, err)
+		}
+	}
+}
+
+func TestEnumFromTagAndType(t *testing.T) {
+	type test struct {
+		A string `json:"a" gskma:"enum=x|y"`
+		B int32  `json:"b" gskma:"enum=1|2"`
+		C color  `json:"c"`
+	}
+
+	s := TypeOf(test{})
+
+	if !reflect.DeepEqual(s.data.Properties["b"].Enum, []interface{}{int32(1), int32(2)}) {
+		t.Errorf("enum tag is not working: %v", s.data.Properties["b"].Enum)
+	}
+
+	if len(s.data.Properties["c"].Enum) != 2 {
+		t.Errorf("enum is not derived from Values method: %v", s.data.Properties["c"].Enum)
+	}
+
+	_, err := s.Validate(test{A: "x", B: 2, C: "red"})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = s.Validate(test{A: "z", B: 3, C: "blue"})
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Errorf("expected 3 enum errors, got %v", err)
+	}
+
+	type other struct {
+		P palette `json:"p"`
+		L level   `json:"l"`
+	}
+
+	s = TypeOf(other{})
+	if s.data.Properties["p"].ref.Enum != nil || s.data.Properties["l"].Enum != nil {
+		t.Errorf("only scalars listing their own values are enums: %v %v", s.data.Properties["p"].ref.Enum, s.data.Properties["l"].Enum)
+	}
+}
+
+func TestRequired(t *testing.T) {
+	type test struct {
+		A string  `json:"a" gskma:"required"`
+		B *int32  `json:"b" gskma:"required"`
+		C float64 `json:"c"`
+	}
+
+	s := TypeOf(test{})
+
+	if !reflect.DeepEqual(s.data.Required, []string{"a", "b"}) {
+		t.Errorf("required tag is not working: %v", s.data.Required)
+	}
+
+	b := int32(1)
+	_, err := s.Validate(test{A: "a", B: &b})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = s.Validate(test{C: 1})
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+
+	if errs[0].Error() != "field a is required" || errs[1].Error() != "field b is required" {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	m := TypeOf(map[string]string{})
+	m = m.Required("name")
+
+	_, err = m.Validate(map[string]string{"name": ""})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = m.Validate(map[string]string{"other": ""})
+	if err == nil {
+		t.Error("Required is not working for maps")
+	}
+}
+
+func TestPattern(t *testing.T) {
+	s := String()
+	s = s.Pattern(`^[a-z]{2,4}$`)
+
+	cases := []OptionTestCase{
+		{schema: s, value: "go", err: false},
+		{schema: s, value: "golang", err: true},
+		{schema: s, value: "GO", err: true},
+	}
+
+	for _, c := range cases {
+		_, err := c.schema.Validate(c.value)
+		if c.err != (err != nil) {
+			t.Error("Pattern is not working for", c.value)
+		}
+	}
+
+	if String().Pattern(`(`).Err() == nil {
+		t.Error("Pattern must report an invalid expression")
+	}
+}
+
+func TestPatternFromStructTag(t *testing.T) {
+	type test struct {
+		A string `json:"a" gskma:"pattern=^[a-z]{1\\,3}$,maxlen=5"`
+		B string `json:"b" gskma:"pattern=^a=b$"`
+	}
+
+	s := TypeOf(test{})
+
+	if s.data.Properties["a"].Pattern != "^[a-z]{1,3}$" || *s.data.Properties["a"].MaxLength != 5 {
+		t.Errorf("pattern tag is not working: %s", s.data.Properties["a"].Pattern)
+	}
+
+	if s.data.Properties["b"].Pattern != "^a=b$" {
+		t.Errorf("pattern tag is not working: %s", s.data.Properties["b"].Pattern)
+	}
+
+	_, err := s.Validate(test{A: "abcd", B: "a=b"})
+	if err == nil {
+		t.Error("pattern tag is not validated")
+	}
+}
+
+func TestECMAPattern(t *testing.T) {
+	cases := []struct {
+		expr  string
+		value string
+		match bool
+	}{
+		{expr: `^\u0041$`, value: "A", match: true},
+		{expr: `^\u{1F600}$`, value: "\U0001F600", match: true},
+		{expr: `^\s$`, value: " ", match: true},
+		{expr: `^[\s]$`, value: "　", match: true},
+		{expr: `^\S$`, value: " ", match: false},
+		{expr: `^\cJ$`, value: "\n", match: true},
+		{expr: `^(?<year>\d{4})$`, value: "2020", match: true},
+		{expr: `^\d$`, value: "٣", match: false},
+	}
+
+	for _, c := range cases {
+		re, err := compilePattern(c.expr)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", c.expr, err)
+			continue
+		}
+		if re.MatchString(c.value) != c.match {
+			t.Errorf("pattern %s must match %q: %v", c.expr, c.value, c.match)
+		}
+	}
+
+	if _, err := compilePattern(`(?=a)`); err == nil {
+		t.Error("lookaheads must not compile")
+	}
+}
+
+func TestFormats(t *testing.T) {
+	cases := []struct {
+		format  string
+		valid   []string
+		invalid []string
+	}{
+		{
+			format:  "date-time",
+			valid:   []string{"2020-12-31T23:59:59Z", "2020-12-31t23:59:59.123+02:00"},
+			invalid: []string{"2020-12-31", "2020-13-01T00:00:00Z", "2020-12-31T23:59:59"},
+		},
+		{
+			format:  "date",
+			valid:   []string{"2020-02-29"},
+			invalid: []string{"2021-02-29", "2020/01/01", "20-01-01"},
+		},
+		{
+			format:  "time",
+			valid:   []string{"23:59:59Z", "08:30:00.5-05:00"},
+			invalid: []string{"24:00:00Z", "08:30", "08:30:00"},
+		},
+		{
+			format:  "duration",
+			valid:   []string{"P1D", "PT1H30M", "P1Y2M3DT4H5M6S", "P2W"},
+			invalid: []string{"P", "PT", "1D", "P1DT", "P1H"},
+		},
+		{
+			format:  "email",
+			valid:   []string{"john@example.com", "john.doe+tag@sub.example.org"},
+			invalid: []string{"john", "john@", "John <john@example.com>"},
+		},
+		{
+			format:  "hostname",
+			valid:   []string{"example.com", "a-b.example", "localhost"},
+			invalid: []string{"", "-a.com", "a-.com", "a..com", "a_b.com", strings.Repeat("a", 64) + ".com"},
+		},
+		{
+			format:  "ipv4",
+			valid:   []string{"127.0.0.1", "255.255.255.255"},
+			invalid: []string{"256.0.0.1", "1.2.3", "::1", "::ffff:127.0.0.1"},
+		},
+		{
+			format:  "ipv6",
+			valid:   []string{"::1", "2001:db8::8a2e:370:7334", "::ffff:127.0.0.1"},
+			invalid: []string{"127.0.0.1", "2001:db8:::1", "g::1"},
+		},
+		{
+			format:  "uri",
+			valid:   []string{"https://example.com/path?q=1", "urn:isbn:0451450523"},
+			invalid: []string{"/relative/path", "example.com", "http://[::1"},
+		},
+		{
+			format:  "uri-reference",
+			valid:   []string{"/relative/path", "https://example.com", "#fragment"},
+			invalid: []string{"http://[::1"},
+		},
+		{
+			format:  "uuid",
+			valid:   []string{"123e4567-e89b-12d3-a456-426614174000"},
+			invalid: []string{"123e4567e89b12d3a456426614174000", "123e4567-e89b-12d3-a456-42661417400g"},
+		},
+		{
+			format:  "json-pointer",
+			valid:   []string{"", "/a/b", "/a~0b/c~1d"},
+			invalid: []string{"a/b", "/a~2", "/a~"},
+		},
+		{
+			format:  "relative-json-pointer",
+			valid:   []string{"0", "1/a", "2#"},
+			invalid: []string{"", "01", "-1", "1a"},
+		},
+		{
+			format:  "regex",
+			valid:   []string{"^[a-z]+$", `\d{3}`},
+			invalid: []string{"(", "[a-"},
+		},
+		{
+			format:  "mac",
+			valid:   []string{"00:1a:2b:3c:4d:5e", "00-1A-2B-3C-4D-5E", "001a.2b3c.4d5e"},
+			invalid: []string{"00:1a:2b:3c:4d", "00:1a:2b:3c:4d:zz"},
+		},
+	}
+
+	for _, c := range cases {
+		s := String()
+		s = s.Format(c.format)
+
+		for _, v := range c.valid {
+			if _, err := s.Validate(v); err != nil {
+				t.Errorf("%s: %q must be valid: %v", c.format, v, err)
+			}
+		}
+
+		for _, v := range c.invalid {
+			if _, err := s.Validate(v); err == nil {
+				t.Errorf("%s: %q must be invalid", c.format, v)
+			}
+		}
+	}
+}
+
+func TestFormatAnnotation(t *testing.T) {
+	type test struct {
+		A string `json:"a" gskma:"format=email"`
+	}
+
+	s := TypeOf(test{})
+
+	if s.data.Properties["a"].Format != "email" {
+		t.Error("format tag is not working")
+	}
+
+	_, err := s.Validate(test{A: "john"})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Keyword != "format" || verr.InstanceLocation != "/a" {
+		t.Errorf("format is not asserted: %v", err)
+	}
+
+	vd := NewValidator().AssertFormat(false)
+	if _, err = vd.Validate(s, test{A: "john"}); err != nil {
+		t.Errorf("format must only be annotated: %v", err)
+	}
+}
+
+func TestCustomFormats(t *testing.T) {
+	sku := func(v string) error {
+		if !strings.HasPrefix(v, "SKU-") {
+			return errors.New("value is not a valid SKU")
+		}
+		return nil
+	}
+
+	RegisterFormat("test-sku", sku)
+
+	s := String()
+	s = s.Format("test-sku")
+
+	if _, err := s.Validate("SKU-1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := s.Validate("1"); err == nil {
+		t.Error("registered format is not checked")
+	}
+
+	k8s := String()
+	k8s = k8s.Format("k8s-name")
+
+	if _, err := k8s.Validate("Invalid_Name"); err != nil {
+		t.Errorf("unknown formats must be ignored by default: %v", err)
+	}
+
+	vd := NewValidator().RegisterFormat("k8s-name", checkHostname)
+	if _, err := vd.Validate(k8s, "Invalid_Name"); err == nil {
+		t.Error("format registered on the validator is not checked")
+	}
+
+	if _, err := defaultValidator.Validate(k8s, "Invalid_Name"); err != nil {
+		t.Error("format registered on a validator must not leak to other validators")
+	}
+
+	vd = NewValidator().RejectUnknownFormats(true)
+	if _, err := vd.Validate(k8s, "name"); err == nil {
+		t.Error("unknown formats must be rejected")
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	type inner struct {
+		Port int32 `json:"port" gskma:"default=8080"`
+	}
+
+	type test struct {
+		Host    string   `json:"host" gskma:"default=localhost"`
+		Retries *int64   `json:"retries" gskma:"default=3"`
+		Ratio   float64  `json:"ratio" gskma:"default=0.5"`
+		Tags    []string `json:"tags" gskma:"default=[\"a\"\\,\"b\"]"`
+		Inner   inner    `json:"inner"`
+		Servers []inner  `json:"servers"`
+	}
+
+	s := TypeOf(test{})
+
+	if s.data.Properties["retries"].Default != int64(3) {
+		t.Errorf("default tag is not coerced: %#v", s.data.Properties["retries"].Default)
+	}
+
+	value := test{Host: "example.com", Servers: []inner{{}, {Port: 80}}}
+
+	vd := NewValidator().ApplyDefaults(true)
+	v, err := vd.Validate(s, &value)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	retries := int64(3)
+	expected := test{
+		Host:    "example.com",
+		Retries: &retries,
+		Ratio:   0.5,
+		Tags:    []string{"a", "b"},
+		Inner:   inner{Port: 8080},
+		Servers: []inner{{Port: 8080}, {Port: 80}},
+	}
+
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("defaults are not applied: %#v", v)
+	}
+
+	if value.Retries != nil || value.Servers[0].Port != 0 {
+		t.Error("applying defaults must not modify the input")
+	}
+
+	v, _ = s.Validate(value)
+	if !reflect.DeepEqual(v, value) {
+		t.Error("defaults must only be applied when enabled")
+	}
+
+	m := TypeOf(map[string]*int32{})
+	m.data.AdditionalProperties.Default = int32(1)
+
+	v, _ = vd.Validate(m, map[string]*int32{"a": nil})
+	if p := v.(map[string]*int32)["a"]; p == nil || *p != 1 {
+		t.Errorf("defaults are not applied to map values: %#v", v)
+	}
+}
+
+func TestUnmarshalSchema(t *testing.T) {
+	doc := `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "person",
+		"type": "object",
+		"description": "a person",
+		"required": ["name"],
+		"properties": {
+			"name": {"title": "full name", "type": "string", "minLength": 2, "pattern": "^[a-z]+$"},
+			"age": {"type": "integer", "format": "int32", "minimum": 0, "maximum": 150, "default": 18},
+			"email": {"type": "string", "format": "email"},
+			"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "enum": ["a", "b"]}},
+			"scores": {"type": "object", "additionalProperties": {"type": "number"}}
+		},
+		"additionalProperties": false
+	}`
+
+	var s Schema
+	if err := json.Unmarshal([]byte(doc), &s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.data.Properties["age"].rkind != reflect.Int32 || s.data.Properties["age"].Default != int32(18) {
+		t.Errorf("kind and default are not derived: %#v", s.data.Properties["age"])
+	}
+
+	if s.data.Properties["name"].Name != "full name" || s.data.Properties["age"].Name != "" {
+		t.Errorf("titles of properties are not kept: %q %q", s.data.Properties["name"].Name, s.data.Properties["age"].Name)
+	}
+
+	var value map[string]interface{}
+	json.Unmarshal([]byte(`{"name": "john", "age": 30, "tags": ["a"], "scores": {"math": 1.5}}`), &value)
+
+	if _, err := s.Validate(value); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	json.Unmarshal([]byte(`{"name": "J", "age": 200, "email": "x", "tags": ["c"], "scores": {"math": "a"}, "other": 1}`), &value)
+	_, err := s.Validate(value)
+
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 7 {
+		t.Fatalf("expected 7 errors, got %v", err)
+	}
+
+	locations := map[string]bool{}
+	for _, e := range errs {
+		locations[e.(*ValidationError).InstanceLocation+" "+e.(*ValidationError).Keyword] = true
+	}
+
+	for _, l := range []string{"/name minLength", "/name pattern", "/age maximum", "/email format", "/tags/0 enum", "/scores/math type", " additionalProperties"} {
+		if !locations[l] {
+			t.Errorf("missing error %q in %v", l, errs)
+		}
+	}
+
+	vd := NewValidator().ApplyDefaults(true)
+	v, _ := vd.Validate(s, map[string]interface{}{"name": "john"})
+	if v.(map[string]interface{})["age"] != int32(18) {
+		t.Errorf("defaults are not applied to loaded schemas: %v", v)
+	}
+
+	out, _ := json.Marshal(&s)
+	var again Schema
+	if err := json.Unmarshal(out, &again); err != nil {
+		t.Errorf("marshalled schema can't be loaded: %v %s", err, out)
+	}
+
+	var typeless Schema
+	if err := json.Unmarshal([]byte(`{"enum": [1, 2]}`), &typeless); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, doc := range []string{"1", "2", "2.0"} {
+		if _, err := typeless.ValidateJSON([]byte(doc)); err != nil {
+			t.Errorf("typeless enum of a loaded schema is not working for %s: %v", doc, err)
+		}
+	}
+
+	if _, err := typeless.Validate(1); err != nil {
+		t.Errorf("typeless enum of a loaded schema is not working for int: %v", err)
+	}
+
+	if _, err := typeless.ValidateJSON([]byte("3")); err == nil {
+		t.Error("typeless enum of a loaded schema must reject values out of the enum")
+	}
+
+	var null Schema
+	if err := json.Unmarshal([]byte(`{"properties": {"gone": {"type": "null"}}}`), &null); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := null.ValidateJSON([]byte(`{"gone": null}`)); err != nil {
+		t.Errorf("null schema must accept null: %v", err)
+	}
+
+	if _, err := null.ValidateJSON([]byte(`{"gone": 1}`)); err == nil {
+		t.Error("null schema must reject values other than null")
+	}
+}
+
+func TestUnmarshalSchemaErrors(t *testing.T) {
+	cases := []struct {
+		doc      string
+		location string
+	}{
+		{doc: `"string"`, location: ""},
+		{doc: `{"type": "text"}`, location: "/type"},
+		{doc: `{"type": ["string", "null"]}`, location: "/type"},
+		{doc: `{"type": "null", "minLength": 1}`, location: "/minLength"},
+		{doc: `{"type": "string", "const": "a"}`, location: "/const"},
+		{doc: `{"type": "integer", "maxLength": 1}`, location: "/maxLength"},
+		{doc: `{"type": "string", "minLength": -1}`, location: "/minLength"},
+		{doc: `{"type": "integer", "multipleOf": 0}`, location: "/multipleOf"},
+		{doc: `{"type": "string", "pattern": "("}`, location: "/pattern"},
+		{doc: `{"type": "integer", "default": "a"}`, location: "/default"},
+		{doc: `{"properties": {"a": {"items": {"type": 1}}}}`, location: "/properties/a/items/type"},
+		{doc: `{"anyOf": [{"type": "string"}, {"type": "strng"}]}`, location: "/anyOf/1/type"},
+	}
+
+	for _, c := range cases {
+		var s Schema
+		err := json.Unmarshal([]byte(c.doc), &s)
+
+		var serr *SchemaError
+		if !errors.As(err, &serr) {
+			t.Errorf("%s: expected SchemaError, got %v", c.doc, err)
+			continue
+		}
+
+		if serr.Location != c.location {
+			t.Errorf("%s: expected error at %q, got %v", c.doc, c.location, serr)
+		}
+	}
+}
+
+func TestValidateDecodedJSON(t *testing.T) {
+	type address struct {
+		Zip string `json:"zip" gskma:"required,maxlen=5"`
+	}
+
+	type person struct {
+		Name    string            `json:"name" gskma:"required,minlen=2"`
+		Age     int32             `json:"age" gskma:"max=150"`
+		Count   int               `json:"count"`
+		Score   float64           `json:"score"`
+		Tags    []string          `json:"tags"`
+		Address []address         `json:"address"`
+		Extra   map[string]string `json:"extra"`
+		Meta    interface{}       `json:"meta"`
+	}
+
+	s := TypeOf(person{})
+
+	decode := func(doc string, useNumber bool) interface{} {
+		var v interface{}
+		dec := json.NewDecoder(strings.NewReader(doc))
+		if useNumber {
+			dec.UseNumber()
+		}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	valid := `{"name": "john", "age": 30, "count": 2, "score": 1, "tags": ["a"],
+		"address": [{"zip": "12345"}], "extra": {"k": "v"}, "meta": {"any": [1, "a"]}}`
+
+	for _, useNumber := range []bool{false, true} {
+		if _, err := s.Validate(decode(valid, useNumber)); err != nil {
+			t.Errorf("unexpected error (UseNumber=%v): %v", useNumber, err)
+		}
+	}
+
+	invalid := `{"name": "j", "age": 30.5, "count": "x", "tags": [1], "address": [{}, {"zip": "123456"}], "extra": {"k": 1}}`
+
+	for _, useNumber := range []bool{false, true} {
+		_, err := s.Validate(decode(invalid, useNumber))
+
+		var errs Errors
+		if !errors.As(err, &errs) {
+			t.Fatalf("expected errors, got %v", err)
+		}
+
+		locations := map[string]bool{}
+		for _, e := range errs {
+			locations[e.(*ValidationError).InstanceLocation+" "+e.(*ValidationError).Keyword] = true
+		}
+
+		expected := []string{"/name minLength", "/age type", "/count type", "/tags/0 type",
+			"/address/0 required", "/address/1/zip maxLength", "/extra/k type"}
+
+		if len(errs) != len(expected) {
+			t.Errorf("expected %d errors, got %v", len(expected), errs)
+		}
+
+		for _, l := range expected {
+			if !locations[l] {
+				t.Errorf("missing error %q in %v (UseNumber=%v)", l, errs, useNumber)
+			}
+		}
+	}
+
+	i := Int64()
+	if v, err := i.Validate(json.Number("42")); err != nil || v != int64(42) {
+		t.Errorf("json.Number is not accepted by integer schemas: %v %v", v, err)
+	}
+
+	str := String()
+	if _, err := str.Validate(json.Number("42")); err == nil {
+		t.Error("json.Number must not be accepted by string schemas")
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	type address struct {
+		Zip string `json:"zip" gskma:"maxlen=5"`
+	}
+
+	type person struct {
+		Name    string    `json:"name" gskma:"required,minlen=2"`
+		Age     int32     `json:"age" gskma:"max=150"`
+		Address []address `json:"address"`
+	}
+
+	s := TypeOf(person{})
+
+	doc := "{\n  \"name\": \"j\",\n  \"age\": 200,\n  \"address\": [\n    {\"zip\": \"1\"},\n    {\"zip\": \"1234567\"}\n  ]\n}"
+
+	_, err := s.ValidateJSON([]byte(doc))
+
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", err)
+	}
+
+	positions := map[string][3]int64{
+		"/name":          {2, 11, 12},
+		"/age":           {3, 10, 26},
+		"/address/1/zip": {6, 13, 76},
+	}
+
+	for _, e := range errs {
+		verr := e.(*ValidationError)
+		pos, ok := positions[verr.InstanceLocation]
+		if !ok {
+			t.Errorf("unexpected error %v", verr)
+			continue
+		}
+
+		if int64(verr.Line) != pos[0] || int64(verr.Column) != pos[1] || verr.Offset != pos[2] {
+			t.Errorf("%s: expected position %v, got %d:%d (%d)", verr.InstanceLocation, pos, verr.Line, verr.Column, verr.Offset)
+		}
+	}
+
+	if errs[2].Error() != "/name (line 2, column 11): value length must be at least 2 character(s)" {
+		t.Errorf("unexpected message: %s", errs[2])
+	}
+
+	v, err := s.ValidateReader(strings.NewReader(`{"name": "john", "age": 30}`))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if v.(map[string]interface{})["age"] != json.Number("30") {
+		t.Errorf("numbers must be decoded as json.Number: %#v", v)
+	}
+
+	for _, doc := range []string{`{"name": }`, `{"name": "john"`, `{"name": "john"} {}`, ``} {
+		if _, err := s.ValidateJSON([]byte(doc)); err == nil || !strings.HasPrefix(err.Error(), "invalid json") {
+			t.Errorf("%q: expected syntax error, got %v", doc, err)
+		}
+	}
+}
+
+func TestBind(t *testing.T) {
+	type address struct {
+		City string `json:"city" gskma:"default=berlin"`
+		Zip  string `json:"zip" gskma:"maxlen=5"`
+	}
+
+	type person struct {
+		Name    string    `json:"name" gskma:"required,minlen=2"`
+		Age     int32     `json:"age" gskma:"max=150"`
+		Active  bool      `json:"active" gskma:"default=true"`
+		Address []address `json:"address"`
+	}
+
+	var p person
+	err := Bind([]byte(`{"name": "john", "age": "30", "address": [{"zip": "12345"}]}`), &p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := person{
+		Name:    "john",
+		Age:     30,
+		Active:  true,
+		Address: []address{{City: "berlin", Zip: "12345"}},
+	}
+
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("unexpected result: %#v", p)
+	}
+
+	err = Bind([]byte(`{"name": "john", "age": "old"}`), &p)
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.InstanceLocation != "/age" {
+		t.Errorf("expected error at /age, got %v", err)
+	}
+
+	if err := Bind([]byte(`{}`), p); err == nil {
+		t.Error("Bind must require a pointer")
+	}
+
+	type counter struct {
+		Count  int32 `json:"count,string" validate:"max=5"`
+		Port   int32 `json:"port,string" gskma:"default=8080"`
+		Active *bool `json:"active,string"`
+	}
+
+	var c counter
+	if err := Bind([]byte(`{"count": "3", "active": "true"}`), &c); err != nil || c.Count != 3 || c.Port != 8080 || c.Active == nil || !*c.Active {
+		t.Errorf("fields tagged string must be bound, got %+v %v", c, err)
+	}
+
+	if err := Bind([]byte(`{"count": "6"}`), &c, TagName("validate")); !errors.As(err, &verr) || verr.InstanceLocation != "/count" {
+		t.Errorf("Bind must build the schema with the options, got %v", err)
+	}
+
+	vd := NewValidator()
+	if vd.bindSchema(&c, nil) != vd.bindSchema(&c, nil) {
+		t.Error("Bind must compile the schema of a type once")
+	}
+
+	if vd.bindSchema(&c, nil) == vd.bindSchema(&c, []TypeOption{TagName("validate")}) {
+		t.Error("Bind must compile the schema of a type once per options")
+	}
+}
+
+type node struct {
+	Name     string `json:"name" gskma:"minlen=1"`
+	Children []node `json:"children"`
+	Parent   *node  `json:"parent"`
+	Leaf     *leaf  `json:"leaf"`
+}
+
+type leaf struct {
+	Value int32 `json:"value" gskma:"max=10"`
+	Next  *leaf `json:"next"`
+}
+
+type tree map[string]tree
+
+type forest struct {
+	Trees []tree  `json:"trees"`
+	Tags  tagList `json:"tags" gskma:"maxitems=1"`
+}
+
+type tagList []string
+
+func TestRecursiveTypes(t *testing.T) {
+	s := TypeOf(node{})
+
+	if s.data.Properties["children"].Items.Ref != "#" {
+		t.Errorf("recursive root is not referenced: %v", s.data.Properties["children"].Items.Ref)
+	}
+
+	def, ok := s.data.Defs["gskma.leaf"]
+	if !ok || s.data.Properties["leaf"].Ref != "#/$defs/gskma.leaf" || def.Properties["next"].Ref != "#/$defs/gskma.leaf" {
+		t.Errorf("struct types are not defined under $defs: %v", s.data.Defs)
+	}
+
+	out, err := json.Marshal(&s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	value := node{
+		Name: "root",
+		Children: []node{
+			{Name: "a", Leaf: &leaf{Value: 1, Next: &leaf{Value: 11}}},
+			{Name: ""},
+		},
+	}
+
+	_, err = s.Validate(value)
+
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+
+	if errs[0].(*ValidationError).InstanceLocation != "/children/0/leaf/next/value" ||
+		errs[1].(*ValidationError).InstanceLocation != "/children/1/name" {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	var loaded Schema
+	if err := json.Unmarshal(out, &loaded); err != nil {
+		t.Fatalf("generated schema can't be loaded: %v", err)
+	}
+
+	var doc interface{}
+	json.Unmarshal([]byte(`{"name": "root", "children": [{"name": "a", "leaf": {"value": 1, "next": {"value": 11}}}, {"name": ""}]}`), &doc)
+
+	_, err = loaded.Validate(doc)
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("expected 2 errors from the loaded schema, got %v", err)
+	}
+
+	if TypeOf(tree{}).data.AdditionalProperties.Ref != "#" {
+		t.Error("recursive map type is not referenced")
+	}
+
+	f := TypeOf(forest{})
+	if f.data.Defs["gskma.tree"] == nil || f.data.Properties["trees"].Items.Ref != "#/$defs/gskma.tree" || f.data.Properties["tags"].Items == nil {
+		t.Errorf("only recursive named maps and slices must be defined under $defs: %v", f.data.Defs)
+	}
+
+	_, err = f.Validate(forest{Trees: []tree{{"a": tree{"b": nil}}}, Tags: tagList{"a", "b"}})
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].(*ValidationError).InstanceLocation != "/tags" {
+		t.Errorf("recursive named types are not working, got %v", err)
+	}
+}
+
+func TestLocalRefs(t *testing.T) {
+	doc := `{
+		"type": "object",
+		"properties": {
+			"billing": {"$ref": "#/definitions/address"},
+			"shipping": {"$ref": "#/$defs/address"},
+			"zip": {"$ref": "#/$defs/address/properties/zip"}
+		},
+		"definitions": {"address": {"$ref": "#/$defs/address"}},
+		"$defs": {
+			"address": {
+				"type": "object",
+				"properties": {"zip": {"type": "string", "maxLength": 5}}
+			}
+		}
+	}`
+
+	var s Schema
+	if err := json.Unmarshal([]byte(doc), &s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := s.Validate(map[string]interface{}{
+		"billing":  map[string]interface{}{"zip": "1234567"},
+		"shipping": map[string]interface{}{"zip": "1234567"},
+		"zip":      "1234567",
+	})
+
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", err)
+	}
+
+	if errs[0].(*ValidationError).SchemaLocation != "/properties/billing/$ref/$ref/properties/zip/maxLength" {
+		t.Errorf("unexpected schema location: %s", errs[0].(*ValidationError).SchemaLocation)
+	}
+
+	var loaded Schema
+	if err := json.Unmarshal([]byte(`{"properties": {"n": {"type": "string"}, "c": {"$ref": "#"}}}`), &loaded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	copied := loaded
+	if err := json.Unmarshal([]byte(`{"properties": {"n": {"type": "integer"}, "c": {"$ref": "#"}}}`), &loaded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := copied.Validate(map[string]interface{}{"n": "a", "c": map[string]interface{}{"n": "b"}}); err != nil {
+		t.Errorf("loading a document must not change the refs of the copies of the schema: %v", err)
+	}
+
+	for _, doc := range []string{`{"$ref": "#/$defs/missing"}`, `{"$ref": "other.json#/a"}`, `{"items": {"$ref": "#/items/items"}}`} {
+		var s Schema
+		err := json.Unmarshal([]byte(doc), &s)
+
+		var serr *SchemaError
+		if !errors.As(err, &serr) {
+			t.Errorf("%s: expected SchemaError, got %v", doc, err)
+		}
+	}
+}
+
+type mapFS map[string]string
+
+func (m mapFS) ReadFile(name string) ([]byte, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", name)
+	}
+	return []byte(data), nil
+}
+
+func TestRegistry(t *testing.T) {
+	person := map[string]interface{}{
+		"name":    "john",
+		"address": map[string]interface{}{"zip": "1234567", "country": "fr"},
+		"friends": []interface{}{
+			map[string]interface{}{"name": "jane", "address": map[string]interface{}{}},
+		},
+	}
+
+	expected := []string{"/address/country", "/address/zip", "/friends/0/address"}
+
+	check := func(name string, r *Registry) {
+		s, err := r.Schema("person.json")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		_, err = s.Validate(person)
+
+		var errs Errors
+		if !errors.As(err, &errs) || len(errs) != len(expected) {
+			t.Fatalf("%s: expected %d errors, got %v", name, len(expected), err)
+		}
+
+		for i, e := range errs {
+			if e.(*ValidationError).InstanceLocation != expected[i] {
+				t.Errorf("%s: unexpected error %v", name, e)
+			}
+		}
+	}
+
+	check("dir", NewRegistry(DirLoader("testdata/schemas")))
+
+	fsys := mapFS{}
+	mem := MemoryLoader{}
+	for _, name := range []string{"person.json", "address.json", "common.json"} {
+		data, err := ioutil.ReadFile("testdata/schemas/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys[name] = string(data)
+		mem[name] = data
+	}
+
+	check("fs", NewRegistry(FSLoader(fsys)))
+	check("memory", NewRegistry(mem))
+
+	r := NewRegistry()
+	for name, data := range mem {
+		if name != "person.json" {
+			if err := r.Add(name, data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	if err := r.Add("person.json", mem["person.json"]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check("added", r)
+
+	a, _ := r.Schema("address.json#/definitions/address")
+	p, _ := r.Schema("person.json")
+	if a.data.Properties["zip"] != p.data.Properties["address"].ref.Properties["zip"] {
+		t.Error("schemas of the registry must share their definitions")
+	}
+
+	if _, err := NewRegistry().Schema("person.json"); err == nil {
+		t.Error("documents must not be loaded without loaders")
+	}
+
+	if _, err := NewRegistry(DirLoader("testdata")).Schema("../gskma_test.go"); err == nil {
+		t.Error("DirLoader must not read files outside its directory")
+	}
+}
+
+func TestRegistryIDs(t *testing.T) {
+	r := NewRegistry(MemoryLoader{
+		"https://example.com/schemas/common.json": []byte(`{"$defs": {"id": {"type": "integer", "minimum": 1}}}`),
+	})
+
+	err := r.Add("local.json", []byte(`{
+		"$id": "https://example.com/schemas/item.json",
+		"type": "object",
+		"properties": {"id": {"$ref": "common.json#/$defs/id"}}
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, uri := range []string{"local.json", "https://example.com/schemas/item.json"} {
+		s, err := r.Schema(uri)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := s.Validate(map[string]interface{}{"id": 0}); err == nil {
+			t.Errorf("%s: refs are not resolved against $id", uri)
+		}
+	}
+
+	if err := r.Add("bad.json", []byte(`{"$ref": "missing.json"}`)); err != nil {
+		t.Errorf("refs must be resolved on first use, got %v", err)
+	}
+
+	if _, err := r.Schema("bad.json"); err == nil {
+		t.Error("unresolvable refs must be reported")
+	}
+
+	if err := r.Add("missing.json", []byte(`{"type": "string"}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := r.Schema("bad.json"); err != nil {
+		t.Errorf("refs must resolve once the missing document is added, got %v", err)
+	}
+}
+
+func TestRegistryCycles(t *testing.T) {
+	r := NewRegistry()
+	docs := map[string]string{
+		"a.json": `{"type": "object", "properties": {"b": {"$ref": "b.json"}, "name": {"type": "string", "maxLength": 3}}}`,
+		"b.json": `{"type": "object", "properties": {"a": {"$ref": "a.json"}, "count": {"type": "integer", "minimum": 1}}}`,
+	}
+
+	for name, data := range docs {
+		if err := r.Add(name, []byte(data)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	s, err := r.Schema("a.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	value := map[string]interface{}{
+		"b": map[string]interface{}{
+			"count": 0,
+			"a":     map[string]interface{}{"name": "golang"},
+		},
+	}
+
+	_, err = s.Validate(value)
+
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+
+	if errs[0].(*ValidationError).InstanceLocation != "/b/a/name" || errs[1].(*ValidationError).InstanceLocation != "/b/count" {
+		t.Errorf("documents referencing each other are not working, got %v", errs)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	s := TypeOf(benchOrder{})
+	c := s.Compile()
+
+	invalid := benchOrderValue
+	invalid.Status = "lost"
+	invalid.Items = []benchItem{{SKU: "A", Quantity: 0, Price: 1}}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(benchOrderJSON, &doc); err != nil {
+		t.Fatal(err)
+	}
+	doc["priority"] = 11
+	doc["customer"] = strings.Repeat("a", 65)
+	delete(doc, "id")
+
+	for _, value := range []interface{}{benchOrderValue, invalid, doc, &invalid} {
+		_, expected := s.Validate(value)
+
+		for i := 0; i < 2; i++ {
+			_, err := c.Validate(value)
+			if fmt.Sprint(err) != fmt.Sprint(expected) {
+				t.Errorf("compiled schema is not working, expected %v, got %v", expected, err)
+			}
+		}
+	}
+
+	_, err := c.Validate(doc)
+	errs := err.(Errors)
+	if len(errs) != 3 || errs[0].(*ValidationError).Keyword != "required" ||
+		errs[1].(*ValidationError).InstanceLocation != "/customer" ||
+		errs[2].(*ValidationError).InstanceLocation != "/priority" {
+		t.Errorf("errors of maps are not reported in a stable order: %v", errs)
+	}
+
+	_, err = c.ValidateJSON([]byte(`{"id": "x", "customer": "a", "items": [{"sku": "abc", "quantity": 1, "price": 1}]}`))
+	if err == nil || err.(Errors)[0].(*ValidationError).Line != 1 {
+		t.Errorf("compiled ValidateJSON is not working: %v", err)
+	}
+
+	tree := TypeOf(node{})
+	if _, err := tree.Compile().Validate(node{Name: "root", Children: []node{{Name: ""}}}); err == nil {
+		t.Error("compiled recursive schema is not working")
+	}
+}
+
+var sharedSchema = TypeOf(benchOrder{})
+
+func TestConcurrentValidate(t *testing.T) {
+	compiled := sharedSchema.Compile()
+	invalid := benchOrderValue
+	invalid.Priority = 11
+
+	done := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			for j := 0; j < 50; j++ {
+				if _, err := sharedSchema.Validate(benchOrderValue); err != nil {
+					done <- err
+					return
+				}
+
+				if _, err := compiled.ValidateJSON(benchOrderJSON); err != nil {
+					done <- err
+					return
+				}
+
+				if _, err := compiled.Validate(invalid); err == nil {
+					done <- errors.New("invalid value passed the validation")
+					return
+				}
+
+				name := String().MinLength(1)
+				name = name.MaxLength(i + 1)
+				if _, err := name.Validate(strings.Repeat("a", i+2)); err == nil {
+					done <- errors.New("schema built by another goroutine is shared")
+					return
+				}
+			}
+			done <- nil
+		}(i)
+	}
+
+	for i := 0; i < 8; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestImmutableBuilders(t *testing.T) {
+	base := TypeOf(map[string]string{}).Required("a")
+	derived := base.Required("b").MaxProperties(1)
+
+	if _, err := base.Validate(map[string]string{"a": "x", "c": "y"}); err != nil {
+		t.Errorf("builders must not modify the schema they are called on: %v", err)
+	}
+
+	if _, err := derived.Validate(map[string]string{"a": "x"}); err == nil {
+		t.Error("builders must return the modified schema")
+	}
+
+	short := String().MaxLength(1)
+	long := short.MaxLength(5)
+	if _, err := short.Validate("abc"); err == nil {
+		t.Error("builders must not modify the schema they are called on")
+	}
+
+	if _, err := long.Validate("abc"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	named := TypeOf(node{}).Required("name")
+	doc := map[string]interface{}{"name": "root", "children": []interface{}{map[string]interface{}{}}}
+	if _, err := named.Validate(doc); err == nil {
+		t.Error("the refs to the root must refer to the modified copy")
+	}
+
+	var loaded Schema
+	out, _ := json.Marshal(named)
+	if err := json.Unmarshal(out, &loaded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := loaded.Required("parent").Validate(doc); err == nil || len(err.(Errors)) != 3 {
+		t.Errorf("the refs to the root of a loaded schema must refer to the modified copy, got %v", err)
+	}
+}
+
+func TestCompileCache(t *testing.T) {
+	s := TypeOf(benchOrder{})
+	if s.Compile() != s.Compile() {
+		t.Error("the compiled form of a schema must be reused")
+	}
+
+	base := String()
+	if _, err := base.Validate("abc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	short := base.MaxLength(2)
+	if _, err := short.Validate("abc"); err == nil {
+		t.Error("schemas returned by builders must not reuse the compiled form of their base")
+	}
+
+	RegisterFormat("cache-test", func(v string) error { return nil })
+	tagged := String().Format("cache-test")
+	if _, err := tagged.Validate("a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	RegisterFormat("cache-test", func(v string) error { return errors.New("value is not cached") })
+	if _, err := tagged.Validate("a"); err == nil {
+		t.Error("formats registered after the first validation must be used")
+	}
+}
+
+type benchItem struct {
+	SKU      string  `json:"sku" gskma:"required,minlen=3,maxlen=16"`
+	Quantity int64   `json:"quantity" gskma:"min=1,max=1000"`
+	Price    float64 `json:"price" gskma:"exclmin=0"`
+}
+
+type benchOrder struct {
+	ID       string      `json:"id" gskma:"required,format=uuid"`
+	Customer string      `json:"customer" gskma:"required,maxlen=64"`
+	Status   string      `json:"status" gskma:"enum=new|paid|shipped"`
+	Priority int32       `json:"priority" gskma:"min=0,max=10"`
+	Items    []benchItem `json:"items" gskma:"minitems=1,maxitems=50"`
+}
+
+var benchOrderValue = benchOrder{
+	ID:       "123e4567-e89b-12d3-a456-426614174000",
+	Customer: "ACME",
+	Status:   "paid",
+	Priority: 3,
+	Items: []benchItem{
+		{SKU: "A-100", Quantity: 2, Price: 9.5},
+		{SKU: "B-200", Quantity: 1, Price: 120},
+		{SKU: "C-300", Quantity: 10, Price: 0.25},
+	},
+}
+
+var benchOrderJSON = []byte(`{
+	"id": "123e4567-e89b-12d3-a456-426614174000",
+	"customer": "ACME",
+	"status": "paid",
+	"priority": 3,
+	"items": [
+		{"sku": "A-100", "quantity": 2, "price": 9.5},
+		{"sku": "B-200", "quantity": 1, "price": 120},
+		{"sku": "C-300", "quantity": 10, "price": 0.25}
+	]
+}`)
+
+func BenchmarkValidateStruct(b *testing.B) {
+	s := TypeOf(benchOrder{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Validate(benchOrderValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidateMap(b *testing.B) {
+	s := TypeOf(benchOrder{})
+	var doc interface{}
+	if err := json.Unmarshal(benchOrderJSON, &doc); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Validate(doc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkValidatorValidateStruct compile the schema on every call, unlike Schema.Validate that reuses its compiled form
+func BenchmarkValidatorValidateStruct(b *testing.B) {
+	s := TypeOf(benchOrder{})
+	vd := NewValidator()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := vd.Validate(s, benchOrderValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledValidateStruct(b *testing.B) {
+	s := TypeOf(benchOrder{})
+	c := s.Compile()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Validate(benchOrderValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledValidateMap(b *testing.B) {
+	s := TypeOf(benchOrder{})
+	c := s.Compile()
+	var doc interface{}
+	if err := json.Unmarshal(benchOrderJSON, &doc); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Validate(doc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledValidateJSON(b *testing.B) {
+	s := TypeOf(benchOrder{})
+	c := s.Compile()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.ValidateJSON(benchOrderJSON); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
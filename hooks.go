@@ -0,0 +1,71 @@
+package gskma
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Schemer is implemented by types that describe their own schema, TypeOf uses the
+// schema returned by JSONSchema instead of reflecting on the type
+// JSONSchema is called on the zero value and must not call TypeOf with its own type
+type Schemer interface {
+	JSONSchema() Schema
+}
+
+// Validatable is implemented by structs with rules that span several fields,
+// Validate is called once the fields of the struct passed their schemas and
+// the error it returns is reported at the location of the struct
+type Validatable interface {
+	Validate() error
+}
+
+var (
+	schemerType     = reflect.TypeOf((*Schemer)(nil)).Elem()
+	validatableType = reflect.TypeOf((*Validatable)(nil)).Elem()
+	validatables    sync.Map
+)
+
+// schemaHook return the schema the type t describes with its JSONSchema method
+func schemaHook(t reflect.Type) (Schema, bool) {
+	if t.Kind() == reflect.Interface {
+		return Schema{}, false
+	}
+
+	switch {
+	case t.Implements(schemerType):
+		return reflect.Zero(t).Interface().(Schemer).JSONSchema(), true
+	case reflect.PtrTo(t).Implements(schemerType):
+		return reflect.New(t).Interface().(Schemer).JSONSchema(), true
+	}
+	return Schema{}, false
+}
+
+// validatable report whether the struct type t or a pointer to it has a Validate method
+func validatable(t reflect.Type) bool {
+	if ok, found := validatables.Load(t); found {
+		return ok.(bool)
+	}
+
+	ok := reflect.PtrTo(t).Implements(validatableType)
+	validatables.Store(t, ok)
+	return ok
+}
+
+// validateHook call the Validate method of the struct v
+func validateHook(v reflect.Value) error {
+	if !v.CanInterface() {
+		return nil
+	}
+	return pointerTo(v).Interface().(Validatable).Validate()
+}
+
+// pointerTo return a pointer to v, v is copied when it isn't addressable
+func pointerTo(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v.Addr()
+	}
+
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p
+}
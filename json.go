@@ -0,0 +1,244 @@
+package gskma
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// document a decoded json document with the position of its values
+type document struct {
+	data     []byte
+	dec      *json.Decoder
+	offsets  map[string]int64
+	newlines []int64
+}
+
+// decodeDocument decode data using json.Number for numbers and record
+// the offset of every value by its JSON Pointer
+func decodeDocument(data []byte) (*document, interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	doc := &document{
+		data:    data,
+		dec:     dec,
+		offsets: make(map[string]int64),
+	}
+
+	v, err := doc.value("")
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			err = nil
+		} else if err == nil {
+			err = &json.SyntaxError{Offset: dec.InputOffset()}
+		}
+	}
+
+	if err != nil {
+		return nil, nil, doc.syntaxError(err)
+	}
+	return doc, v, nil
+}
+
+func (d *document) value(ptr string) (interface{}, error) {
+	d.offsets[ptr] = d.start()
+
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := make(map[string]interface{})
+		for d.dec.More() {
+			key, err := d.dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			k := key.(string)
+			obj[k], err = d.value(ptr + "/" + pointerEscaper.Replace(k))
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = d.dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := make([]interface{}, 0)
+		for i := 0; d.dec.More(); i++ {
+			v, err := d.value(ptr + "/" + strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err = d.dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// start return the offset of the next value skipping the separators
+// the decoder doesn't consume until the next token is read
+func (d *document) start() int64 {
+	off := d.dec.InputOffset()
+	for off < int64(len(d.data)) && strings.IndexByte(" \t\r\n,:", d.data[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+// position return the 1-based line and column of an offset
+func (d *document) position(offset int64) (int, int) {
+	if d.newlines == nil {
+		d.newlines = []int64{-1}
+		for i, c := range d.data {
+			if c == '\n' {
+				d.newlines = append(d.newlines, int64(i))
+			}
+		}
+	}
+
+	line := sort.Search(len(d.newlines), func(i int) bool { return d.newlines[i] >= offset })
+	return line, int(offset - d.newlines[line-1])
+}
+
+func (d *document) syntaxError(err error) error {
+	var serr *json.SyntaxError
+	if errors.As(err, &serr) {
+		line, col := d.position(serr.Offset)
+		return fmt.Errorf("invalid json at line %d, column %d: %w", line, col, err)
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		line, col := d.position(int64(len(d.data)))
+		return fmt.Errorf("invalid json at line %d, column %d: unexpected end of input", line, col)
+	}
+	return fmt.Errorf("invalid json: %w", err)
+}
+
+// locate set the position in the document of the values of the errors
+func (d *document) locate(errs Errors) {
+	for _, err := range errs {
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			continue
+		}
+
+		if off, ok := d.offsets[verr.InstanceLocation]; ok {
+			verr.Offset = off
+			verr.Line, verr.Column = d.position(off)
+		}
+		d.locate(verr.Causes)
+	}
+}
+
+// ValidateJSON decode the json document and validate it against the schema
+// the errors hold the position of the invalid values in the document
+func (vd *Validator) ValidateJSON(s Schema, data []byte) (interface{}, error) {
+	return vd.Compile(s).ValidateJSON(data)
+}
+
+// ValidateReader read the json document from r and validate it against the schema
+func (vd *Validator) ValidateReader(s Schema, r io.Reader) (interface{}, error) {
+	return vd.Compile(s).ValidateReader(r)
+}
+
+// ValidateJSON decode the json document and validate it against the compiled schema
+// the errors hold the position of the invalid values in the document
+func (c *Compiled) ValidateJSON(data []byte) (interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	doc, value, err := decodeDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := c.Validate(value)
+	if errs, ok := err.(Errors); ok {
+		doc.locate(errs)
+	}
+	return v, err
+}
+
+// ValidateReader read the json document from r and validate it against the compiled schema
+func (c *Compiled) ValidateReader(r io.Reader) (interface{}, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.ValidateJSON(data)
+}
+
+// bindKey identify the schemas compiled by Bind, the schema of a type depends
+// on the tag its constraints are read from
+type bindKey struct {
+	typ    reflect.Type
+	tag    string
+	legacy bool
+}
+
+// Bind validate the json document against the schema of dst, apply the defaults
+// and the conversions of the schema like "1" to int32 then decode the result into dst
+// dst must be a non-nil pointer, the schema of its type is built with the options
+// and compiled on the first call, the compiled schemas are dropped when the
+// format options of the validator change
+func (vd *Validator) Bind(data []byte, dst interface{}, opts ...TypeOption) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("dst must be a non-nil pointer")
+	}
+
+	v, err := vd.bindSchema(dst, opts).ValidateJSON(data)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(out, dst)
+}
+
+// bindSchema return the compiled schema of the type of dst used by Bind, it's
+// compiled once per type and options and again when formats are registered
+func (vd *Validator) bindSchema(dst interface{}, opts []TypeOption) *Compiled {
+	b := newBuilder(opts...)
+	key := bindKey{typ: reflect.TypeOf(dst), tag: b.tag, legacy: b.legacy}
+
+	version := atomic.LoadUint64(&formatsVersion)
+	if vd.binds != nil {
+		if c, ok := vd.binds.Load(key); ok && c.(*cachedCompile).version == version {
+			return c.(*cachedCompile).compiled
+		}
+	}
+
+	bind := *vd
+	bind.applyDefaults = true
+	compiled := bind.Compile(TypeOf(dst, opts...))
+
+	if vd.binds != nil {
+		vd.binds.Store(key, &cachedCompile{version: version, compiled: compiled})
+	}
+	return compiled
+}
+
+// Bind validate the json document against the schema of dst and decode it into dst
+// using the default validator
+func Bind(data []byte, dst interface{}, opts ...TypeOption) error {
+	return defaultValidator.Bind(data, dst, opts...)
+}
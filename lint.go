@@ -0,0 +1,131 @@
+package gskma
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Finding problem found by Lint in a schema
+type Finding struct {
+	// Location JSON Pointer to the keyword in the schema
+	Location string
+	// Keyword the keyword the finding is about
+	Keyword string
+	// Message human readable description of the problem
+	Message string
+}
+
+// String return the message prefixed with the location
+func (f Finding) String() string {
+	return f.Location + ": " + f.Message
+}
+
+// Lint check the schema and its subschemas for keywords that contradict each other,
+// limits that no value can satisfy and defaults or enum values that violate their schema
+// the misuses of the builder methods are reported as findings as well
+func (s Schema) Lint() []Finding {
+	var findings []Finding
+	for _, err := range s.errs {
+		if serr, ok := err.(*SchemaError); ok {
+			findings = append(findings, Finding{Location: serr.Location, Keyword: keywordOf(serr.Location), Message: serr.Message})
+		}
+	}
+
+	s.data.lint("", &findings)
+	return findings
+}
+
+func (s *schema) lint(ptr string, findings *[]Finding) {
+	report := func(keyword string, format string, args ...interface{}) {
+		*findings = append(*findings, Finding{
+			Location: ptr + "/" + keyword,
+			Keyword:  keywordOf(keyword),
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	counts := []struct {
+		min, max       *int
+		minKey, maxKey string
+	}{
+		{s.MinLength, s.MaxLength, "minLength", "maxLength"},
+		{s.MinItems, s.MaxItems, "minItems", "maxItems"},
+		{s.MinProperties, s.MaxProperties, "minProperties", "maxProperties"},
+	}
+	for _, c := range counts {
+		if c.min != nil && *c.min < 0 {
+			report(c.minKey, "%s must not be negative", c.minKey)
+		}
+		if c.max != nil && *c.max < 0 {
+			report(c.maxKey, "%s must not be negative", c.maxKey)
+		}
+		if c.min != nil && c.max != nil && *c.min > *c.max {
+			report(c.minKey, "%s %d is greater than %s %d", c.minKey, *c.min, c.maxKey, *c.max)
+		}
+	}
+
+	bounds := []struct {
+		min, max       *float64
+		minKey, maxKey string
+		exclusive      bool
+	}{
+		{s.Minimum, s.Maximum, "minimum", "maximum", false},
+		{s.Minimum, s.ExclusiveMaximum, "minimum", "exclusiveMaximum", true},
+		{s.ExclusiveMinimum, s.Maximum, "exclusiveMinimum", "maximum", true},
+		{s.ExclusiveMinimum, s.ExclusiveMaximum, "exclusiveMinimum", "exclusiveMaximum", true},
+	}
+	for _, b := range bounds {
+		if b.min == nil || b.max == nil {
+			continue
+		}
+		if *b.min > *b.max || b.exclusive && *b.min == *b.max {
+			report(b.minKey, "no value satisfies %s %v and %s %v", b.minKey, *b.min, b.maxKey, *b.max)
+		}
+	}
+
+	if s.MultipleOf != nil && *s.MultipleOf <= 0 {
+		report("multipleOf", "multipleOf must be greater than 0")
+	}
+
+	if s.AdditionalProperties != nil && s.AdditionalProperties.reject {
+		for i, name := range s.Required {
+			if _, ok := s.Properties[name]; !ok {
+				report("required/"+strconv.Itoa(i), "required property %s is not allowed by additionalProperties", name)
+			}
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		plain := *s
+		plain.Enum = nil
+		plain.Default = nil
+		for i, value := range s.Enum {
+			if _, err := defaultValidator.Validate(Schema{data: plain}, value); err != nil {
+				report("enum/"+strconv.Itoa(i), "enum value %v is invalid: %s", value, err)
+			}
+		}
+	}
+
+	if s.Default != nil {
+		if _, err := defaultValidator.Validate(Schema{data: *s}, s.Default); err != nil {
+			report("default", "default value %v is invalid: %s", s.Default, err)
+		}
+	}
+
+	_ = s.subschemas(func(sub string, child *schema) error {
+		child.lint(ptr+sub, findings)
+		return nil
+	})
+}
+
+// keywordOf return the keyword of a JSON Pointer to a keyword, skipping array indexes
+func keywordOf(ptr string) string {
+	tokens := strings.Split(ptr, "/")
+	for i := len(tokens) - 1; i >= 0; i-- {
+		if _, err := strconv.Atoi(tokens[i]); err != nil {
+			return tokens[i]
+		}
+	}
+	return ""
+}
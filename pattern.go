@@ -0,0 +1,83 @@
+package gskma
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// white space characters matched by \s in ECMA-262
+const ecmaSpace = `\t\n\v\f\r \x{a0}\x{1680}\x{2000}-\x{200a}\x{2028}\x{2029}\x{202f}\x{205f}\x{3000}\x{feff}`
+
+// compilePattern compile an ECMA-262 regular expression
+// the syntax that RE2 doesn't share with ECMA-262 is translated where possible,
+// features RE2 doesn't support like lookarounds and backreferences fail to compile
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile(translatePattern(expr))
+}
+
+func translatePattern(expr string) string {
+	var b strings.Builder
+	inClass := false
+
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+
+		switch {
+		case c == '\\' && i+1 < len(expr):
+			i++
+			switch next := expr[i]; {
+			case next == 'u' && i+1 < len(expr) && expr[i+1] == '{':
+				end := strings.IndexByte(expr[i:], '}')
+				if end < 0 {
+					b.WriteString(`\u`)
+					continue
+				}
+				b.WriteString(`\x` + expr[i+1:i+end+1])
+				i += end
+			case next == 'u' && i+4 < len(expr) && isHex(expr[i+1:i+5]):
+				b.WriteString(`\x{` + expr[i+1:i+5] + `}`)
+				i += 4
+			case next == 'c' && i+1 < len(expr) && isLetter(expr[i+1]):
+				fmt.Fprintf(&b, `\x{%02x}`, expr[i+1]%32)
+				i++
+			case next == 's' && inClass:
+				b.WriteString(ecmaSpace)
+			case next == 's':
+				b.WriteString(`[` + ecmaSpace + `]`)
+			case next == 'S' && !inClass:
+				b.WriteString(`[^` + ecmaSpace + `]`)
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(next)
+			}
+		case c == '[' && !inClass:
+			inClass = true
+			b.WriteByte(c)
+		case c == ']' && inClass:
+			inClass = false
+			b.WriteByte(c)
+		case c == '(' && !inClass && strings.HasPrefix(expr[i:], "(?<") &&
+			!strings.HasPrefix(expr[i:], "(?<=") && !strings.HasPrefix(expr[i:], "(?<!"):
+			b.WriteString("(?P<")
+			i += 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,230 @@
+package gskma
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// subschemas call fn with every direct subschema and its location relative to s
+func (s *schema) subschemas(fn func(ptr string, sub *schema) error) error {
+	for _, keyword := range []string{"properties", "$defs", "definitions"} {
+		m := s.schemaMap(keyword)
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if err := fn(location{}.at(keyword, name).schema, m[name]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.Items != nil {
+		if err := fn("/items", s.Items); err != nil {
+			return err
+		}
+	}
+
+	if s.AdditionalProperties != nil {
+		if err := fn("/additionalProperties", s.AdditionalProperties); err != nil {
+			return err
+		}
+	}
+
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		list := s.composition(keyword)
+		for i := range list {
+			if err := fn("/"+keyword+"/"+strconv.Itoa(i), &list[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *schema) schemaMap(keyword string) map[string]*schema {
+	switch keyword {
+	case "properties":
+		return s.Properties
+	case "$defs":
+		return s.Defs
+	case "definitions":
+		return s.Definitions
+	}
+	return nil
+}
+
+func (s *schema) composition(keyword string) []schema {
+	switch keyword {
+	case "allOf":
+		return s.AllOf
+	case "anyOf":
+		return s.AnyOf
+	case "oneOf":
+		return s.OneOf
+	}
+	return nil
+}
+
+// lookup return the subschema at the JSON Pointer tokens or nil if there is none
+func (s *schema) lookup(tokens []string) *schema {
+	if len(tokens) == 0 {
+		return s
+	}
+
+	switch tokens[0] {
+	case "properties", "$defs", "definitions":
+		if len(tokens) > 1 {
+			if sub, ok := s.schemaMap(tokens[0])[tokens[1]]; ok {
+				return sub.lookup(tokens[2:])
+			}
+		}
+	case "items":
+		if s.Items != nil {
+			return s.Items.lookup(tokens[1:])
+		}
+	case "additionalProperties":
+		if s.AdditionalProperties != nil {
+			return s.AdditionalProperties.lookup(tokens[1:])
+		}
+	case "allOf", "anyOf", "oneOf":
+		list := s.composition(tokens[0])
+		if len(tokens) > 1 {
+			i, err := strconv.Atoi(tokens[1])
+			if err == nil && i >= 0 && i < len(list) {
+				return list[i].lookup(tokens[2:])
+			}
+		}
+	}
+	return nil
+}
+
+// resolve return the schema a local $ref like #/$defs/name points to
+func (s *schema) resolve(ref string) (*schema, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("remote $ref %q is not supported", ref)
+	}
+
+	fragment, err := url.PathUnescape(ref[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid $ref %q", ref)
+	}
+
+	if fragment == "" {
+		return s, nil
+	}
+
+	if fragment[0] != '/' {
+		return nil, fmt.Errorf("$ref %q must be a JSON Pointer", ref)
+	}
+
+	tokens := strings.Split(fragment[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = pointerUnescaper.Replace(t)
+	}
+
+	target := s.lookup(tokens)
+	if target == nil {
+		return nil, fmt.Errorf("unresolvable $ref %q", ref)
+	}
+	return target, nil
+}
+
+// hasRefs report whether s or its subschemas have a $ref or definitions
+func (s *schema) hasRefs() bool {
+	found := s.ref != nil || len(s.Defs) > 0 || len(s.Definitions) > 0
+	s.subschemas(func(_ string, sub *schema) error {
+		found = found || sub.hasRefs()
+		return nil
+	})
+	return found
+}
+
+// relocate return a copy of the schema to be placed at the JSON Pointer prefix of
+// another document, the local refs of the copy point to the copied subschemas
+// and are prefixed so that they still resolve once the document is marshalled
+func relocate(s Schema, prefix string) *schema {
+	copies := make(map[*schema]*schema)
+	root := copySchema(&s.data, copies)
+	if s.root != nil {
+		copies[s.root] = root
+	}
+
+	for _, c := range copies {
+		target, ok := copies[c.ref]
+		if c.ref == nil || !ok {
+			continue
+		}
+		c.ref = target
+		if strings.HasPrefix(c.Ref, "#") {
+			c.Ref = prefix + c.Ref[1:]
+		}
+	}
+	return root
+}
+
+// copySchema return a deep copy of s, the copy of every subschema is recorded in copies
+func copySchema(s *schema, copies map[*schema]*schema) *schema {
+	c := *s
+	copies[s] = &c
+
+	copyMap := func(m map[string]*schema) map[string]*schema {
+		if m == nil {
+			return nil
+		}
+		out := make(map[string]*schema, len(m))
+		for name, sub := range m {
+			out[name] = copySchema(sub, copies)
+		}
+		return out
+	}
+
+	copyList := func(list []schema) []schema {
+		if list == nil {
+			return nil
+		}
+		out := make([]schema, len(list))
+		for i := range list {
+			out[i] = *copySchema(&list[i], copies)
+			copies[&list[i]] = &out[i]
+		}
+		return out
+	}
+
+	c.Properties = copyMap(s.Properties)
+	c.Defs = copyMap(s.Defs)
+	c.Definitions = copyMap(s.Definitions)
+	c.AllOf = copyList(s.AllOf)
+	c.AnyOf = copyList(s.AnyOf)
+	c.OneOf = copyList(s.OneOf)
+	if s.Items != nil {
+		c.Items = copySchema(s.Items, copies)
+	}
+	if s.AdditionalProperties != nil {
+		c.AdditionalProperties = copySchema(s.AdditionalProperties, copies)
+	}
+	return &c
+}
+
+// resolveRefs resolve the $ref of s and its subschemas with the resolver
+func resolveRefs(s *schema, ptr string, resolver func(ref string) (*schema, error)) error {
+	if s.Ref != "" && s.ref == nil {
+		target, err := resolver(s.Ref)
+		if err != nil {
+			return &SchemaError{Location: ptr + "/$ref", Message: err.Error()}
+		}
+		s.ref = target
+	}
+
+	return s.subschemas(func(sub string, child *schema) error {
+		return resolveRefs(child, ptr+sub, resolver)
+	})
+}
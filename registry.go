@@ -0,0 +1,243 @@
+package gskma
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Loader load the schema document identified by uri
+type Loader interface {
+	Load(uri string) ([]byte, error)
+}
+
+// LoaderFunc adapt a function to the Loader interface
+type LoaderFunc func(uri string) ([]byte, error)
+
+// Load call f(uri)
+func (f LoaderFunc) Load(uri string) ([]byte, error) {
+	return f(uri)
+}
+
+// MemoryLoader load documents from a map of uri to document
+type MemoryLoader map[string][]byte
+
+// Load return the document of uri
+func (m MemoryLoader) Load(uri string) ([]byte, error) {
+	data, ok := m[uri]
+	if !ok {
+		return nil, fmt.Errorf("schema %q not found", uri)
+	}
+	return data, nil
+}
+
+// ReadFileFS file system that can read files, implemented by embed.FS
+type ReadFileFS interface {
+	ReadFile(name string) ([]byte, error)
+}
+
+// FSLoader load documents from a file system like embed.FS
+// the path of the uri is used as the name of the file
+func FSLoader(fsys ReadFileFS) Loader {
+	return LoaderFunc(func(uri string) ([]byte, error) {
+		name, err := uriPath(uri)
+		if err != nil {
+			return nil, err
+		}
+		return fsys.ReadFile(strings.TrimPrefix(name, "/"))
+	})
+}
+
+// DirLoader load documents from the files of dir
+// the path of the uri is used as the path of the file relative to dir
+func DirLoader(dir string) Loader {
+	return LoaderFunc(func(uri string) ([]byte, error) {
+		name, err := uriPath(uri)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	})
+}
+
+// uriPath return the cleaned path of the uri, it never leaves the root
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+
+	p := u.Path
+	if p == "" {
+		p = u.Opaque
+	}
+	return path.Clean("/" + p), nil
+}
+
+// Registry set of schema documents that reference each other by uri
+// the documents are looked up in the registry first then loaded with the
+// loaders in order, nothing is fetched from the network
+type Registry struct {
+	mu      sync.Mutex
+	loaders []Loader
+	docs    map[string]*schema
+	pending map[*schema]pendingDoc
+}
+
+// pendingDoc document whose $ref are not resolved yet
+type pendingDoc struct {
+	uri  string
+	base string
+}
+
+// NewRegistry create a registry that loads missing documents with the loaders
+func NewRegistry(loaders ...Loader) *Registry {
+	return &Registry{
+		loaders: loaders,
+		docs:    make(map[string]*schema),
+		pending: make(map[*schema]pendingDoc),
+	}
+}
+
+// Add add the schema document under uri, and under its $id if it has one
+// the $ref of the document are resolved against its $id or uri when it's first
+// used by Schema, so documents that reference each other can be added in any order
+func (r *Registry) Add(uri string, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.add(uri, data)
+	return err
+}
+
+// Schema return the schema at ref, a uri with an optional JSON Pointer fragment
+// like other.json#/definitions/address, the schemas of the registry share their definitions
+func (r *Registry) Schema(ref string) (Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.resolve("", ref)
+	if err != nil {
+		return Schema{}, err
+	}
+	return Schema{data: *s, root: s}.renew(), nil
+}
+
+func (r *Registry) add(uri string, data []byte) (*schema, error) {
+	doc := &schema{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", uri, err)
+	}
+
+	var meta struct {
+		ID string `json:"$id"`
+	}
+	json.Unmarshal(data, &meta)
+
+	base := uri
+	if meta.ID != "" {
+		id, err := resolveURI(uri, stripFragment(meta.ID))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid $id: %w", uri, err)
+		}
+		base = id
+	}
+
+	r.docs[uri] = doc
+	r.docs[base] = doc
+	r.pending[doc] = pendingDoc{uri: uri, base: base}
+	return doc, nil
+}
+
+// link resolve the $ref of doc and of the documents it references, a document
+// is unmarked before its refs are resolved so that cycles between documents end
+func (r *Registry) link(doc *schema) error {
+	p, ok := r.pending[doc]
+	if !ok {
+		return nil
+	}
+	delete(r.pending, doc)
+
+	err := resolveRefs(doc, "", func(ref string) (*schema, error) {
+		return r.resolve(p.base, ref)
+	})
+	if err != nil {
+		// the missing documents may still be added, retry on the next use
+		r.pending[doc] = p
+		return fmt.Errorf("%s: %w", p.uri, err)
+	}
+	return nil
+}
+
+// resolve return the schema ref points to, relative refs are resolved against base
+func (r *Registry) resolve(base, ref string) (*schema, error) {
+	uri, err := resolveURI(base, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	docURI := stripFragment(uri)
+	doc, err := r.document(docURI)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.link(doc); err != nil {
+		return nil, err
+	}
+
+	if fragment := uri[len(docURI):]; fragment != "" {
+		return doc.resolve(fragment)
+	}
+	return doc, nil
+}
+
+func (r *Registry) document(uri string) (*schema, error) {
+	if doc, ok := r.docs[uri]; ok {
+		return doc, nil
+	}
+
+	for _, l := range r.loaders {
+		data, err := l.Load(uri)
+		if err == nil {
+			return r.add(uri, data)
+		}
+	}
+	return nil, fmt.Errorf("schema %q not found", uri)
+}
+
+// resolveURI resolve ref against base, the result stays relative when both are
+func resolveURI(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if r.IsAbs() || b.IsAbs() {
+		return b.ResolveReference(r).String(), nil
+	}
+
+	if r.Path == "" {
+		r.Path = b.Path
+	} else if !strings.HasPrefix(r.Path, "/") {
+		r.Path = path.Join(path.Dir(b.Path), r.Path)
+	}
+	return r.String(), nil
+}
+
+func stripFragment(uri string) string {
+	if i := strings.IndexByte(uri, '#'); i >= 0 {
+		return uri[:i]
+	}
+	return uri
+}
@@ -1,109 +1,388 @@
 package gskma
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
-func getField(f reflect.StructField) *schema {
-	s := newSchema(f.Type)
-	tag := f.Tag.Get("json")
+// jsonOptions options of the json tag that are not constraints
+var jsonOptions = map[string]bool{
+	"omitempty": true,
+	"omitzero":  true,
+	"string":    true,
+}
 
-	if tag == "" {
-		s.Name = f.Name
-		return s
+func (b *builder) getField(owner reflect.Type, sf structField) *schema {
+	f := sf.field
+	s := b.newSchema(f.Type)
+	s.Name = sf.name
+	names := splitTag(f.Tag.Get("json"))
+
+	var legacy []string
+	for _, option := range names[1:] {
+		if option == "omitempty" || option == "omitzero" {
+			s.omitEmpty = true
+		}
+		if option == "string" && quotable(f.Type) {
+			s.quoted = true
+		}
+		if !jsonOptions[option] && option != "" {
+			legacy = append(legacy, option)
+		}
 	}
 
-	segments := strings.Split(tag, ",")
-	if segments[0] == "-" {
-		return nil
+	var segments []string
+	if tag := f.Tag.Get(b.tag); tag != "" {
+		segments = splitTag(tag)
 	}
 
-	s.Name = segments[0]
+	switch {
+	case b.legacy && len(legacy) > 0:
+		b.warn(fmt.Sprintf("gskma: field %s.%s: constraints in the json tag are deprecated, move %q to the %s tag",
+			owner, f.Name, strings.Join(legacy, ","), b.tag))
+		segments = append(legacy, segments...)
+	case len(legacy) > 0:
+		for _, segment := range legacy {
+			b.errs = append(b.errs, &TagError{
+				Type:    owner.String(),
+				Field:   f.Name,
+				Segment: segment,
+				Message: "constraints in the json tag are ignored, move it to the " + b.tag + " tag or use LegacyTags",
+			})
+		}
+	}
 
-	for _, segment := range segments[1:] {
-		parts := strings.Split(segment, "=")
-		switch parts[0] {
-		case "max", "maximum":
-			v, err := converToFloat64(parts[1])
-			if err == nil {
-				s.Maximum = &v
-			}
-		case "min", "minimum":
-			v, err := converToFloat64(parts[1])
-			if err == nil {
-				s.Minimum = &v
-			}
-		case "exclmax", "exclusiveMaximum":
-			v, err := converToFloat64(parts[1])
-			if err == nil {
-				s.ExclusiveMaximum = &v
-			}
-		case "exclmin", "exclusiveMinimum":
-			v, err := converToFloat64(parts[1])
-			if err == nil {
-				s.ExclusiveMinimum = &v
-			}
-		case "maxlen", "maxLength":
-			v, err := converToInt64(parts[1])
-			a := int(v)
-			if err == nil {
-				s.MaxLength = &a
-			}
-		case "minlen", "minLength":
-			v, err := converToInt64(parts[1])
-			a := int(v)
-			if err == nil {
-				s.MinLength = &a
-			}
-		case "multof", "multipleOf":
-			v, err := converToInt64(parts[1])
-			if err == nil {
-				s.MultipleOf = &v
-			}
-		case "maxitems", "maxItems":
-			v, err := converToInt64(parts[1])
-			a := int(v)
-			if err == nil {
-				s.MaxItems = &a
-			}
-		case "minitems", "minItems":
-			v, err := converToInt64(parts[1])
-			a := int(v)
-			if err == nil {
-				s.MinItems = &a
-			}
-		case "maxprops", "maxProperties":
-			v, err := converToInt64(parts[1])
-			a := int(v)
-			if err == nil {
-				s.MaxProperties = &a
-			}
-		case "minprops", "minProperties":
-			v, err := converToInt64(parts[1])
-			a := int(v)
-			if err == nil {
-				s.MinProperties = &a
+	for _, err := range b.applyTag(s, f, segments) {
+		err.Type, err.Field = owner.String(), f.Name
+		b.errs = append(b.errs, err)
+	}
+	return s
+}
+
+// tagKeys the schema keyword set by each key of the tag, the short keys
+// are kept from the time the constraints were written in the json tag
+var tagKeys = map[string]string{
+	"required":         "required",
+	"max":              "maximum",
+	"maximum":          "maximum",
+	"min":              "minimum",
+	"minimum":          "minimum",
+	"exclmax":          "exclusiveMaximum",
+	"exclusiveMaximum": "exclusiveMaximum",
+	"exclmin":          "exclusiveMinimum",
+	"exclusiveMinimum": "exclusiveMinimum",
+	"maxlen":           "maxLength",
+	"maxLength":        "maxLength",
+	"minlen":           "minLength",
+	"minLength":        "minLength",
+	"multof":           "multipleOf",
+	"multipleOf":       "multipleOf",
+	"maxitems":         "maxItems",
+	"maxItems":         "maxItems",
+	"minitems":         "minItems",
+	"minItems":         "minItems",
+	"maxprops":         "maxProperties",
+	"maxProperties":    "maxProperties",
+	"minprops":         "minProperties",
+	"minProperties":    "minProperties",
+	"pattern":          "pattern",
+	"default":          "default",
+	"format":           "format",
+	"enum":             "enum",
+}
+
+// applyTag set the constraints of the tag segments on the schema of the field,
+// the segments that can't be applied are skipped and returned as errors
+func (b *builder) applyTag(s *schema, f reflect.StructField, segments []string) []*TagError {
+	var errs []*TagError
+
+	typ := s.Type
+	if s.ref != nil {
+		typ = s.ref.Type
+	}
+
+	for _, segment := range segments {
+		if segment == "" {
+			continue
+		}
+
+		fail := func(format string, args ...interface{}) {
+			errs = append(errs, &TagError{Segment: segment, Message: fmt.Sprintf(format, args...)})
+		}
+
+		parts := strings.SplitN(segment, "=", 2)
+		keyword, ok := tagKeys[parts[0]]
+		switch {
+		case !ok:
+			fail("unknown constraint %s", parts[0])
+			continue
+		case keyword == "required":
+			if len(parts) == 2 {
+				fail("required doesn't take a value")
+				continue
 			}
+			s.required = true
+			continue
+		case len(parts) == 1:
+			fail("%s requires a value", parts[0])
+			continue
+		}
+
+		applies := keywords[keyword]
+		if keyword == "format" {
+			applies = "string"
+		}
+		if typ != "" && applies != "" && applies != typ && !(applies == "number" && typ == "integer") {
+			fail("%s can be used only with %s fields, not %s", keyword, applies, f.Type)
+			continue
+		}
+
+		if err := setTagKeyword(s, f, keyword, parts[1]); err != nil {
+			fail("%s", err)
 		}
 	}
-	return s
+	return errs
 }
 
-func newSchema(t reflect.Type) *schema {
+func setTagKeyword(s *schema, f reflect.StructField, keyword, value string) error {
+	limits := map[string]**float64{
+		"maximum":          &s.Maximum,
+		"minimum":          &s.Minimum,
+		"exclusiveMaximum": &s.ExclusiveMaximum,
+		"exclusiveMinimum": &s.ExclusiveMinimum,
+	}
+	counts := map[string]**int{
+		"maxLength":     &s.MaxLength,
+		"minLength":     &s.MinLength,
+		"maxItems":      &s.MaxItems,
+		"minItems":      &s.MinItems,
+		"maxProperties": &s.MaxProperties,
+		"minProperties": &s.MinProperties,
+	}
+
+	if p, ok := limits[keyword]; ok {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*p = &v
+		return nil
+	}
+
+	if p, ok := counts[keyword]; ok {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		if v < 0 {
+			return fmt.Errorf("%s must not be negative", keyword)
+		}
+		*p = &v
+		return nil
+	}
+
+	switch keyword {
+	case "multipleOf":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		if v <= 0 {
+			return fmt.Errorf("multipleOf must be greater than 0")
+		}
+		s.MultipleOf = &v
+	case "pattern":
+		re, err := compilePattern(value)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+		s.Pattern = value
+		s.pattern = re
+	case "default":
+		v, err := parseDefault(value, f.Type, s)
+		if err != nil {
+			return fmt.Errorf("invalid default: %s", err)
+		}
+		s.Default = v
+	case "format":
+		s.Format = value
+	case "enum":
+		enum, err := parseEnum(value, s)
+		if err != nil {
+			return fmt.Errorf("invalid enum: %s", err)
+		}
+		s.Enum = enum
+	}
+	return nil
+}
+
+// builder build schemas from go types, named struct types are defined once
+// under $defs and referenced with $ref so that recursive types are supported
+type builder struct {
+	root     *schema
+	rootType reflect.Type
+	types    map[reflect.Type]string
+	defs     map[string]*schema
+	tag      string
+	legacy   bool
+	warn     func(string)
+	errs     Errors
+	hookErrs Errors
+}
+
+func newBuilder(opts ...TypeOption) *builder {
+	b := &builder{
+		types: make(map[reflect.Type]string),
+		defs:  make(map[string]*schema),
+		tag:   "gskma",
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// build return the schema of the root type with the definitions it references
+func (b *builder) build(t reflect.Type) *schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	b.root = &schema{}
+	b.rootType = t
+
+	b.fill(b.root, t)
+	if len(b.defs) > 0 {
+		b.root.Defs = b.defs
+	}
+	return b.root
+}
+
+func (b *builder) newSchema(t reflect.Type) *schema {
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
 
-	s := schema{rkind: t.Kind()}
+	if reflected(t) && t.Name() != "" && (t.Kind() == reflect.Struct || recursive(t)) {
+		return b.reference(t)
+	}
+
+	s := &schema{}
+	b.fill(s, t)
+	return s
+}
+
+// reference return a $ref to the definition of a named struct type
+// the definition is registered before it is filled so that it can refer to itself
+func (b *builder) reference(t reflect.Type) *schema {
+	if t == b.rootType {
+		return &schema{Ref: "#", ref: b.root}
+	}
+
+	name, ok := b.types[t]
+	if !ok {
+		name = b.defName(t)
+		b.types[t] = name
+		b.defs[name] = &schema{}
+		b.fill(b.defs[name], t)
+	}
+	return &schema{Ref: "#/$defs/" + pointerEscaper.Replace(name), ref: b.defs[name]}
+}
+
+// define the schema returned by the JSONSchema method of t under $defs and return
+// a $ref to it, the local refs of the schema are moved under its definition
+func (b *builder) define(t reflect.Type, hook Schema) *schema {
+	name, ok := b.types[t]
+	if !ok {
+		name = b.defName(t)
+		b.types[t] = name
+		b.defs[name] = relocate(hook, "#/$defs/"+pointerEscaper.Replace(name))
+	}
+	return &schema{Ref: "#/$defs/" + pointerEscaper.Replace(name), ref: b.defs[name]}
+}
+
+// defName return a unique name for the definition of the type
+func (b *builder) defName(t reflect.Type) string {
+	name := t.String()
+	for i := 2; b.defs[name] != nil; i++ {
+		name = fmt.Sprintf("%s_%d", t.String(), i)
+	}
+	return name
+}
+
+// recursive report whether the map, slice or array type t contains itself,
+// like type Tree map[string]Tree, such types are defined once like structs
+func recursive(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+	default:
+		return false
+	}
+
+	seen := make(map[reflect.Type]bool)
+	var reaches func(u reflect.Type) bool
+	reaches = func(u reflect.Type) bool {
+		if u == t {
+			return true
+		}
+		if seen[u] || !reflected(u) {
+			return false
+		}
+		seen[u] = true
+
+		switch u.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Array:
+			return reaches(u.Elem())
+		case reflect.Struct:
+			for _, f := range cachedFields(u) {
+				if reaches(f.field.Type) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return reaches(t.Elem())
+}
+
+// reflected report whether the schema of the type is built from its fields
+// rather than given by its JSONSchema method or the well known types
+func reflected(t reflect.Type) bool {
+	if _, ok := wellKnown[t]; ok {
+		return false
+	}
+	return !t.Implements(schemerType) && !reflect.PtrTo(t).Implements(schemerType)
+}
+
+func (b *builder) fill(s *schema, t reflect.Type) {
+	if hook, ok := schemaHook(t); ok {
+		*s = hook.data
+		if hook.data.hasRefs() {
+			*s = *b.define(t, hook)
+		}
+		b.hookErrs = append(b.hookErrs, hook.errs...)
+		return
+	}
+
+	if known, ok := wellKnown[t]; ok {
+		*s = known
+		return
+	}
+
+	s.rkind = t.Kind()
 
 	if t.Kind() == reflect.Struct {
 		s.ID = t.String()
 		s.Name = t.Name()
 		s.Type = "object"
 		s.Properties = make(map[string]*schema)
-		for i := 0; i < t.NumField(); i++ {
-			f := getField(t.Field(i))
+		for _, sf := range cachedFields(t) {
+			f := b.getField(t, sf)
 			s.Properties[f.Name] = f
 			if f.required {
 				s.Required = append(s.Required, f.Name)
@@ -111,16 +390,125 @@ func newSchema(t reflect.Type) *schema {
 		}
 	} else if t.Kind() == reflect.Map {
 		s.Type = "object"
-		s.AdditionalProperties = newSchema(t.Elem())
+		s.AdditionalProperties = b.newSchema(t.Elem())
 
 	} else if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
 		s.Type = "array"
-		s.Items = newSchema(t.Elem())
+		s.Items = b.newSchema(t.Elem())
 	} else {
 		s.Type = types[t.Kind()]
 		s.Format = formats[t.Kind()]
 	}
-	return &s
+
+	s.Enum = enumOf(t)
+}
+
+// enumOf return the values of types that list their allowed values
+// through a method like `func (Color) Values() []Color`, only named
+// strings, booleans and numbers are enums, other types may have an
+// unrelated Values method that can't be called on their zero value
+func enumOf(t reflect.Type) []interface{} {
+	switch types[t.Kind()] {
+	case "string", "boolean", "integer", "number":
+	default:
+		return nil
+	}
+
+	m, ok := t.MethodByName("Values")
+	if !ok || m.Type.NumIn() != 1 || m.Type.NumOut() != 1 || m.Type.Out(0) != reflect.SliceOf(t) {
+		return nil
+	}
+
+	values := reflect.Zero(t).MethodByName("Values").Call(nil)[0]
+	enum := make([]interface{}, values.Len())
+	for i := 0; i < values.Len(); i++ {
+		enum[i] = values.Index(i).Interface()
+	}
+	return enum
+}
+
+func parseEnum(tag string, s *schema) ([]interface{}, error) {
+	values := strings.Split(tag, "|")
+	enum := make([]interface{}, len(values))
+	for i, value := range values {
+		v, err := castIfNumeric(value, s.rkind)
+		if err != nil {
+			return nil, err
+		}
+		enum[i] = v
+	}
+	return enum, nil
+}
+
+func inEnum(v reflect.Value, enum []interface{}) bool {
+	value := basicValue(v)
+	for _, e := range enum {
+		if equalValues(value, basicValue(reflect.ValueOf(e))) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValues compare two basic values, numbers are compared by value whatever their kind
+func equalValues(x, y interface{}) bool {
+	if rx, ok := x.(*big.Rat); ok {
+		ry, ok := y.(*big.Rat)
+		return ok && rx.Cmp(ry) == 0
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// basicValue return the value as its underlying basic type so that
+// named types like `type Color string` compare equal to plain values
+// numbers of every kind, json.Number included, are returned as *big.Rat
+func basicValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return new(big.Rat).SetFloat64(f)
+		}
+		return v.Float()
+	case reflect.String:
+		if v.Type() == numberType {
+			if r, ok := new(big.Rat).SetString(v.String()); ok {
+				return r
+			}
+		}
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	}
+
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// splitTag split the tag on commas, a comma escaped with a backslash
+// is kept in the segment so that values like patterns can contain commas
+func splitTag(tag string) []string {
+	var segments []string
+	var b strings.Builder
+
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case tag[i] == '\\' && i+1 < len(tag) && tag[i+1] == ',':
+			b.WriteByte(',')
+			i++
+		case tag[i] == ',':
+			segments = append(segments, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(tag[i])
+		}
+	}
+	return append(segments, b.String())
 }
 
 func nameOfField(f reflect.StructField) string {
@@ -138,17 +526,33 @@ func nameOfField(f reflect.StructField) string {
 	return segments[0]
 }
 
-func castIfNumeric(v interface{}, s *schema) (interface{}, error) {
+func castIfNumeric(v interface{}, kind reflect.Kind) (interface{}, error) {
 	if v == nil {
 		return v, nil
 	}
 
 	var err error
-	switch s.rkind {
+	switch kind {
+	case reflect.Int:
+		v, err = converToPlatformInt(v)
+	case reflect.Int8:
+		v, err = converToInt8(v)
+	case reflect.Int16:
+		v, err = converToInt16(v)
 	case reflect.Int32:
 		v, err = converToInt32(v)
 	case reflect.Int64:
 		v, err = converToInt64(v)
+	case reflect.Uint:
+		v, err = converToPlatformUint(v)
+	case reflect.Uint8:
+		v, err = converToUint8(v)
+	case reflect.Uint16:
+		v, err = converToUint16(v)
+	case reflect.Uint32:
+		v, err = converToUint32(v)
+	case reflect.Uint64:
+		v, err = converToUint64(v)
 	case reflect.Float32:
 		v, err = converToFloat32(v)
 	case reflect.Float64:
@@ -158,3 +562,126 @@ func castIfNumeric(v interface{}, s *schema) (interface{}, error) {
 	}
 	return v, err
 }
+
+// schemaList return the data of the schemas and their errors located under the keyword
+func schemaList(keyword string, schemas []Schema) ([]schema, Errors) {
+	var errs Errors
+	list := make([]schema, len(schemas))
+	for i, s := range schemas {
+		list[i] = s.data
+		for _, err := range s.errs {
+			errs = append(errs, prefixError(err, keyword, strconv.Itoa(i)))
+		}
+	}
+	return list, errs
+}
+
+// checkMax return why max can't be the maximum of a length or a count
+func checkMax(keyword string, max int, minKeyword string, min *int) string {
+	if max < 0 {
+		return keyword + " must not be negative"
+	}
+	if min != nil && *min > max {
+		return fmt.Sprintf("%s %d is less than %s %d", keyword, max, minKeyword, *min)
+	}
+	return ""
+}
+
+// checkMin return why min can't be the minimum of a length or a count
+func checkMin(keyword string, min int, maxKeyword string, max *int) string {
+	if min < 0 {
+		return keyword + " must not be negative"
+	}
+	if max != nil && *max < min {
+		return fmt.Sprintf("%s %d is greater than %s %d", keyword, min, maxKeyword, *max)
+	}
+	return ""
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDefault parse the default value of a field from its tag, maps, arrays
+// and structs are written as json with their commas escaped
+func parseDefault(value string, t reflect.Type, s *schema) (interface{}, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		v := reflect.New(t)
+		if err := json.Unmarshal([]byte(value), v.Interface()); err != nil {
+			return nil, err
+		}
+		return v.Elem().Interface(), nil
+	}
+	return castIfNumeric(value, s.rkind)
+}
+
+// convertTo return val as a value of type t, numbers are converted
+// to the numeric kind of t and pointers are allocated when needed
+func convertTo(val reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if !val.IsValid() {
+		return invalid, false
+	}
+
+	if val.Type().AssignableTo(t) {
+		return val, true
+	}
+
+	if t.Kind() == reflect.Ptr {
+		elem, ok := convertTo(val, t.Elem())
+		if !ok {
+			return invalid, false
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(elem)
+		return p, true
+	}
+
+	if (val.Kind() == t.Kind() || isNumeric(val.Kind()) && isNumeric(t.Kind())) && val.Type().ConvertibleTo(t) {
+		return val.Convert(t), true
+	}
+	return invalid, false
+}
+
+// assign set val to dst if it can be converted to its type
+func assign(dst reflect.Value, val reflect.Value) {
+	if !dst.CanSet() {
+		return
+	}
+
+	if v, ok := convertTo(val, dst.Type()); ok {
+		dst.Set(v)
+	}
+}
+
+// setMapIndex set the value of key in m to val, or to fallback if valid
+// and val can't be converted to the element type of the map
+func setMapIndex(m reflect.Value, key, val, fallback reflect.Value) {
+	if v, ok := convertTo(val, m.Type().Elem()); ok {
+		m.SetMapIndex(key, v)
+	} else if fallback.IsValid() {
+		m.SetMapIndex(key, fallback)
+	}
+}
+
+// quotable report whether the `json:",string"` option applies to fields of type t,
+// encoding/json encodes numbers and booleans of such fields inside a json string
+func quotable(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return isNumeric(t.Kind()) || t.Kind() == reflect.Bool
+}
+
+func isNumeric(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
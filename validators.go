@@ -3,137 +3,573 @@ package gskma
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"sync"
 )
 
 var invalid = reflect.Value{}
 
-func validate(v reflect.Value, s *schema) (reflect.Value, error) {
-	if v.Kind() == reflect.Ptr {
+var defaultValidator = NewValidator()
+
+// Validator validate values against schemas using a set of options
+// it's safe for concurrent use once its options are set
+type Validator struct {
+	assertFormat  bool
+	rejectUnknown bool
+	applyDefaults bool
+	formats       map[string]func(string) error
+	binds         *sync.Map
+}
+
+// NewValidator create a validator with the default options
+// formats are asserted by default
+func NewValidator() *Validator {
+	return &Validator{
+		assertFormat: true,
+		binds:        new(sync.Map),
+	}
+}
+
+// AssertFormat set whether the format keyword is asserted
+// or only used as an annotation
+func (vd *Validator) AssertFormat(assert bool) *Validator {
+	vd.assertFormat = assert
+	vd.binds = new(sync.Map)
+	return vd
+}
+
+// RegisterFormat register a checker for a format used only by this validator,
+// it takes precedence over the globally registered checkers
+func (vd *Validator) RegisterFormat(name string, fn func(string) error) *Validator {
+	if vd.formats == nil {
+		vd.formats = make(map[string]func(string) error)
+	}
+	vd.formats[name] = fn
+	vd.binds = new(sync.Map)
+	return vd
+}
+
+// RejectUnknownFormats set whether a format without a checker fails the validation
+// or is ignored, ignored by default
+func (vd *Validator) RejectUnknownFormats(reject bool) *Validator {
+	vd.rejectUnknown = reject
+	vd.binds = new(sync.Map)
+	return vd
+}
+
+// ApplyDefaults set whether Validate returns a copy of the value where missing
+// or zero fields of structs and maps are filled from the defaults of their schemas
+func (vd *Validator) ApplyDefaults(apply bool) *Validator {
+	vd.applyDefaults = apply
+	return vd
+}
+
+func (vd *Validator) format(name string) (func(string) error, bool) {
+	if fn, ok := vd.formats[name]; ok {
+		return fn, true
+	}
+	return lookupFormat(name)
+}
+
+// Compile compile the schema into a tree of validators using the options of the validator,
+// formats are looked up once so checkers must be registered before compiling
+// the misuses of the builder methods of the schema are returned by every validation
+func (vd *Validator) Compile(s Schema) *Compiled {
+	if err := s.Err(); err != nil {
+		return &Compiled{err: err}
+	}
+
+	c := compiler{vd: vd, rules: make(map[*schema]*rule), doc: s.root, root: &s.data}
+	return &Compiled{
+		root:     c.compile(&s.data),
+		defaults: vd.applyDefaults,
+	}
+}
+
+// Validate validate value against the schema
+// all the violations are collected and returned together as Errors
+// the schema is compiled on every call, use Compile to validate many values with the same options
+func (vd *Validator) Validate(s Schema, value interface{}) (interface{}, error) {
+	return vd.Compile(s).Validate(value)
+}
+
+// Compiled schema compiled into a tree of validators, it is immutable
+// and safe for concurrent use, compile once and validate many values
+type Compiled struct {
+	root     *rule
+	defaults bool
+	err      error
+}
+
+var states = sync.Pool{
+	New: func() interface{} { return new(state) },
+}
+
+// Validate validate value against the compiled schema
+// all the violations are collected and returned together as Errors
+func (c *Compiled) Validate(value interface{}) (interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	st := states.Get().(*state)
+	st.defaults = c.defaults
+	val := c.root.validate(st, reflect.ValueOf(value))
+
+	errs := st.errs
+	st.errs = nil
+	states.Put(st)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	if val.Kind() == reflect.Invalid {
+		return nil, nil
+	}
+
+	return val.Interface(), nil
+}
+
+// token of the instance location, array items keep their index
+// so that it's formatted only when a violation is reported
+type token struct {
+	name  string
+	index int
+	item  bool
+}
+
+// state holds the violations found during a single validation
+// and the location of the value being validated
+type state struct {
+	defaults bool
+	errs     Errors
+	instance []token
+	schema   []string
+}
+
+// mark position of the state in the locations, restored with leave
+type mark struct {
+	instance, schema int
+}
+
+// enter move to a nested value validated by the subschema at the keywords
+func (st *state) enter(t token, keywords ...string) mark {
+	m := st.at(keywords...)
+	st.instance = append(st.instance, t)
+	return m
+}
+
+// at move to a subschema applied to the same value
+func (st *state) at(keywords ...string) mark {
+	m := mark{instance: len(st.instance), schema: len(st.schema)}
+	st.schema = append(st.schema, keywords...)
+	return m
+}
+
+func (st *state) leave(m mark) {
+	st.instance = st.instance[:m.instance]
+	st.schema = st.schema[:m.schema]
+}
+
+func (st *state) location() location {
+	var loc location
+	for _, t := range st.instance {
+		if t.item {
+			loc = loc.child(strconv.Itoa(t.index))
+		} else {
+			loc = loc.child(t.name)
+		}
+	}
+	return loc.at(st.schema...)
+}
+
+func (st *state) report(keyword string, v reflect.Value, limit interface{}, format string, args ...interface{}) *ValidationError {
+	var value interface{}
+	if v.IsValid() && v.CanInterface() {
+		value = v.Interface()
+	}
+
+	loc := st.location()
+	err := &ValidationError{
+		InstanceLocation: loc.instance,
+		Keyword:          keyword,
+		SchemaLocation:   loc.at(keyword).schema,
+		Value:            value,
+		Limit:            limit,
+		Message:          fmt.Sprintf(format, args...),
+	}
+	st.errs = append(st.errs, err)
+	return err
+}
+
+func (r *rule) validate(st *state, v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
 		v = v.Elem()
 	}
 
 	kind := v.Kind()
-	if kind == reflect.Invalid && s.Default != nil {
-		return reflect.ValueOf(s.Default), nil
+	if kind == reflect.Invalid && r.def != nil {
+		if r.quoted {
+			return reflect.ValueOf(fmt.Sprint(r.def))
+		}
+		return reflect.ValueOf(r.def)
+	}
+
+	// values of well known types are checked in their json form and kept as they are
+	if kind == reflect.Struct || kind == reflect.Slice {
+		if encoded, ok, err := encodeKnown(v); ok {
+			if err != nil {
+				st.report("type", v, r.typ, "%s", err)
+				return invalid
+			}
+			r.validate(st, encoded)
+			return v
+		}
+	}
+
+	if r.reject {
+		st.report("", v, nil, "value is not allowed")
+		return invalid
+	}
+
+	if r.ref != nil {
+		m := st.at("$ref")
+		v = r.ref.validate(st, v)
+		st.leave(m)
+		kind = v.Kind()
 	}
 
-	if kind != s.rkind && kind != reflect.Invalid {
-		return invalid, fmt.Errorf("invalid type, expected value of type %s", s.Type)
+	// fields tagged `json:",string"` hold their number in a json string, the number
+	// is checked and the string is kept so that it decodes into the field again
+	raw := v
+
+	if kind != reflect.Invalid && !r.compatible(v) {
+		if !v.CanInterface() {
+			st.report("type", v, r.typ, "invalid type, expected value of type %s", r.typ)
+			return invalid
+		}
+
+		cast, err := castIfNumeric(v.Interface(), r.kind)
+		if err != nil {
+			st.report("type", v, r.typ, err.Error())
+			return invalid
+		}
+
+		v = reflect.ValueOf(cast)
+		kind = v.Kind()
+		if !r.compatible(v) {
+			st.report("type", v, r.typ, "invalid type, expected value of type %s", r.typ)
+			return invalid
+		}
+	}
+
+	if len(r.enum) > 0 && kind != reflect.Invalid && !inEnum(v, r.enum) {
+		st.report("enum", v, r.enum, "value must be one of %v", r.enum)
+	}
+
+	if len(r.allOf) > 0 || len(r.anyOf) > 0 || len(r.oneOf) > 0 {
+		v = r.validateComposition(st, v)
 	}
 
 	switch kind {
 	case reflect.String:
-		return validateString(v, s)
-	case reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
-		return validateNumber(v, s)
+		checks := r.strings
+		if v.Type() == numberType {
+			checks = r.numbers
+		}
+		for _, c := range checks {
+			c(st, v)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		for _, c := range r.numbers {
+			c(st, v)
+		}
 	case reflect.Map:
-		return validateMap(v, s)
+		v = r.validateMap(st, v)
 	case reflect.Struct:
-		return validateStruct(v, s)
+		v = r.validateStruct(st, v)
 	case reflect.Array, reflect.Slice:
-		return validateArray(v, s)
-	default:
-		return v, nil
+		v = r.validateArray(st, v)
+	}
+
+	if r.quoted && raw.Kind() == reflect.String {
+		return raw
 	}
+	return v
 }
 
-func validateString(v reflect.Value, s *schema) (reflect.Value, error) {
-	if s.MaxLength != nil && v.Len() > *s.MaxLength {
-		return invalid, fmt.Errorf("value length must not exceed %d character(s)", *s.MaxLength)
+func (r *rule) validateStruct(st *state, v reflect.Value) reflect.Value {
+	for _, c := range r.objects {
+		c(st, v)
 	}
 
-	if s.MinLength != nil && v.Len() < *s.MinLength {
-		return invalid, fmt.Errorf("value length must be at least %d character(s)", *s.MinLength)
+	out := v
+	if st.defaults {
+		out = reflect.New(v.Type()).Elem()
+		out.Set(v)
 	}
-	return v, nil
-}
 
-func validateNumber(v reflect.Value, s *schema) (reflect.Value, error) {
-	var val float64
+	before := len(st.errs)
+	for _, f := range r.fieldsOf(v.Type()) {
+		value, ok := fieldByIndex(v, f.index)
+		if !ok {
+			if f.required {
+				st.report("required", v, f.name, "field %s is required", f.name)
+			}
+			continue
+		}
 
-	switch s.rkind {
-	case reflect.Int32, reflect.Int64:
-		val = float64(v.Int())
-	case reflect.Float32, reflect.Float64:
-		val = float64(v.Float())
-	}
+		if st.defaults && !f.indirect && f.prop != nil && f.prop.def != nil && value.IsZero() {
+			assign(out.FieldByIndex(f.index), reflect.ValueOf(f.prop.def))
+			continue
+		}
+
+		if f.required && value.IsZero() {
+			st.report("required", v, f.name, "field %s is required", f.name)
+			continue
+		}
+
+		// zero fields tagged omitempty or omitzero are left out of the json encoding
+		if f.prop != nil && f.prop.omit && value.IsZero() {
+			continue
+		}
+
+		var val reflect.Value
+		switch {
+		case f.prop != nil:
+			m := st.enter(token{name: f.name}, "properties", f.name)
+			val = f.prop.validate(st, value)
+			st.leave(m)
+		case r.additional != nil && r.additional.reject:
+			if !value.IsZero() {
+				st.report("additionalProperties", v, f.name, "property %s is not allowed", f.name)
+			}
+			continue
+		case r.additional != nil:
+			m := st.enter(token{name: f.name}, "additionalProperties")
+			val = r.additional.validate(st, value)
+			st.leave(m)
+		default:
+			continue
+		}
 
-	if s.Maximum != nil && val > *s.Maximum {
-		return invalid, fmt.Errorf("value must be less than or equal %v", *s.Maximum)
+		if st.defaults && !f.indirect {
+			assign(out.FieldByIndex(f.index), val)
+		}
 	}
 
-	if s.Minimum != nil && val < *s.Minimum {
-		return invalid, fmt.Errorf("value must be greater than or equal %v", *s.Minimum)
+	// cross field rules of the struct can rely on its fields being valid, a rule
+	// holding a $ref leaves them to the rule it refers to
+	if r.ref == nil && len(st.errs) == before && validatable(v.Type()) {
+		if err := validateHook(out); err != nil {
+			st.report("", v, nil, "%s", err)
+		}
 	}
+	return out
+}
+
+// span errors reported for a key of a map
+type span struct {
+	key        string
+	start, end int
+}
 
-	if s.ExclusiveMaximum != nil && val >= *s.ExclusiveMaximum {
-		return invalid, fmt.Errorf("value must be less than %v", *s.ExclusiveMaximum)
+func (r *rule) validateMap(st *state, v reflect.Value) reflect.Value {
+	for _, c := range r.objects {
+		c(st, v)
 	}
 
-	if s.ExclusiveMinimum != nil && val <= *s.ExclusiveMinimum {
-		return invalid, fmt.Errorf("value must be greater than %v", *s.ExclusiveMinimum)
+	out := v
+	if st.defaults {
+		out = reflect.MakeMapWithSize(v.Type(), v.Len())
 	}
 
-	if s.MultipleOf != nil && v.Int()%(*s.MultipleOf) != 0 {
-		return invalid, fmt.Errorf("value must be divisible by %d", *s.MultipleOf)
+	keyType := v.Type().Key()
+	for i, name := range r.required {
+		if keyType.Kind() != reflect.String {
+			break
+		}
+
+		key := r.keys[i]
+		if keyType != stringType {
+			key = key.Convert(keyType)
+		}
+
+		if !v.MapIndex(key).IsValid() {
+			st.report("required", v, name, "field %s is required", name)
+		}
 	}
 
-	return v, nil
-}
+	// keys are visited in the order of the map, the errors are sorted by key
+	// afterwards so that they're reported in a stable order
+	var spans []span
+	iter := v.MapRange()
+	for iter.Next() {
+		k, value := iter.Key(), iter.Value()
+
+		var key string
+		if k.Kind() == reflect.String {
+			key = k.String()
+		} else {
+			key = fmt.Sprint(k.Interface())
+		}
 
-func validateStruct(v reflect.Value, s *schema) (reflect.Value, error) {
-	if s.MaxProperties != nil && v.Len() > *s.MaxProperties {
-		return invalid, fmt.Errorf("value must not have more than %d item(s)", *s.MaxProperties)
+		start := len(st.errs)
+
+		val := value
+		switch prop, ok := r.props[key]; {
+		case ok:
+			m := st.enter(token{name: key}, "properties", key)
+			val = prop.validate(st, value)
+			st.leave(m)
+		case r.additional != nil && r.additional.reject:
+			st.report("additionalProperties", v, key, "property %s is not allowed", key)
+			val = invalid
+			value = invalid
+		case r.additional != nil:
+			m := st.enter(token{name: key}, "additionalProperties")
+			val = r.additional.validate(st, value)
+			st.leave(m)
+		}
+
+		if len(st.errs) > start {
+			spans = append(spans, span{key: key, start: start, end: len(st.errs)})
+		}
+
+		if st.defaults {
+			setMapIndex(out, k, val, value)
+		}
 	}
 
-	if s.MinProperties != nil && v.Len() < *s.MinProperties {
-		return invalid, fmt.Errorf("value must have at least %d item(s)", *s.MinProperties)
+	if len(spans) > 1 {
+		st.sortSpans(spans)
 	}
 
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Type().Field(i)
-		value := v.Field(i)
-		_, err := validate(value, s.Properties[nameOfField(field)])
-		if err != nil {
-			return invalid, err
+	if st.defaults && keyType.Kind() == reflect.String {
+		for _, d := range r.defaults {
+			key := d.key
+			if keyType != stringType {
+				key = key.Convert(keyType)
+			}
+
+			if !v.MapIndex(key).IsValid() {
+				setMapIndex(out, key, d.value, invalid)
+			}
 		}
 	}
-	return v, nil
+	return out
 }
 
-func validateMap(v reflect.Value, s *schema) (reflect.Value, error) {
-	if s.MaxProperties != nil && v.Len() > *s.MaxProperties {
-		return invalid, fmt.Errorf("value must not have more than %d item(s)", *s.MaxProperties)
+// sortSpans reorder the errors of the spans, which follow each other, by key
+func (st *state) sortSpans(spans []span) {
+	first := spans[0].start
+	errs := make(Errors, 0, len(st.errs)-first)
+
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].key < spans[j].key })
+	for _, s := range spans {
+		errs = append(errs, st.errs[s.start:s.end]...)
 	}
+	copy(st.errs[first:], errs)
+}
 
-	if s.MinProperties != nil && v.Len() < *s.MinProperties {
-		return invalid, fmt.Errorf("value must have at least %d item(s)", *s.MinProperties)
+func (r *rule) validateArray(st *state, v reflect.Value) reflect.Value {
+	for _, c := range r.arrays {
+		c(st, v)
 	}
 
-	for _, k := range v.MapKeys() {
-		_, err := validate(v.MapIndex(k), s.AdditionalProperties)
-		if err != nil {
-			return invalid, err
+	out := v
+	if st.defaults {
+		out = reflect.New(v.Type()).Elem()
+		if v.Kind() == reflect.Slice {
+			out.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+		}
+		reflect.Copy(out, v)
+	}
+
+	if r.items == nil {
+		return out
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		m := st.enter(token{index: i, item: true}, "items")
+		val := r.items.validate(st, v.Index(i))
+		st.leave(m)
+
+		if st.defaults {
+			assign(out.Index(i), val)
 		}
 	}
+	return out
+}
 
-	return v, nil
+// branch validate v against a subschema in isolation and return its violations
+func (st *state) branch(r *rule, v reflect.Value) (reflect.Value, Errors) {
+	sub := state{defaults: st.defaults, instance: st.instance, schema: st.schema}
+	val := r.validate(&sub, v)
+	return val, sub.errs
 }
 
-func validateArray(v reflect.Value, s *schema) (reflect.Value, error) {
-	if s.MaxItems != nil && v.Len() > *s.MaxItems {
-		return invalid, fmt.Errorf("value must not have more than %d item(s)", *s.MaxItems)
+func (r *rule) validateComposition(st *state, v reflect.Value) reflect.Value {
+	result := v
+
+	for i, sub := range r.allOf {
+		m := st.at("allOf", strconv.Itoa(i))
+		val := sub.validate(st, v)
+		st.leave(m)
+		if i == 0 {
+			result = val
+		}
 	}
 
-	if s.MinItems != nil && v.Len() < *s.MinItems {
-		return invalid, fmt.Errorf("value must have at least %d item(s)", *s.MinItems)
+	if len(r.anyOf) > 0 {
+		var causes Errors
+		matched := false
+		for i, sub := range r.anyOf {
+			m := st.at("anyOf", strconv.Itoa(i))
+			val, errs := st.branch(sub, v)
+			st.leave(m)
+			if len(errs) == 0 {
+				result = val
+				matched = true
+				break
+			}
+			causes = append(causes, errs...)
+		}
+
+		if !matched {
+			err := st.report("anyOf", v, nil, "value must match at least one schema of anyOf")
+			err.Causes = causes
+		}
 	}
 
-	for i := 0; i < v.Len(); i++ {
-		_, err := validate(v.Index(i), s.Items)
-		if err != nil {
-			return invalid, err
+	if len(r.oneOf) > 0 {
+		var causes Errors
+		var matches []int
+		for i, sub := range r.oneOf {
+			m := st.at("oneOf", strconv.Itoa(i))
+			val, errs := st.branch(sub, v)
+			st.leave(m)
+			if len(errs) == 0 {
+				if len(matches) == 0 {
+					result = val
+				}
+				matches = append(matches, i)
+				continue
+			}
+			causes = append(causes, errs...)
+		}
+
+		if len(matches) == 0 {
+			err := st.report("oneOf", v, nil, "value must match exactly one schema of oneOf, but matched none")
+			err.Causes = causes
+		} else if len(matches) > 1 {
+			st.report("oneOf", v, nil, "value must match exactly one schema of oneOf, but matched %v", matches)
 		}
 	}
-	return v, nil
+
+	return result
 }
@@ -0,0 +1,72 @@
+package gskma
+
+import (
+	"encoding"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// wellKnown schemas of standard library types, they describe the json encoding
+// of the type instead of its Go structure
+var wellKnown = map[reflect.Type]schema{
+	reflect.TypeOf(time.Time{}): {
+		Type:   "string",
+		Format: "date-time",
+		rkind:  reflect.String,
+	},
+	// encoding/json encodes durations as nanoseconds and can't decode them from strings
+	reflect.TypeOf(time.Duration(0)):  Int64().data,
+	reflect.TypeOf(json.RawMessage{}): {},
+	reflect.TypeOf(net.IP{}): {
+		Type:  "string",
+		AnyOf: []schema{{Format: "ipv4"}, {Format: "ipv6"}},
+		rkind: reflect.String,
+	},
+	reflect.TypeOf(url.URL{}): {
+		Type:   "string",
+		Format: "uri",
+		rkind:  reflect.String,
+	},
+	// integer of any size, values are checked as json.Number and keep their type
+	reflect.TypeOf(big.Int{}): {
+		Type: "integer",
+	},
+}
+
+// encodeKnown return the json form of a value of a well known type so that it
+// can be checked against the schema of the type, ok is false for other values
+func encodeKnown(v reflect.Value) (encoded reflect.Value, ok bool, err error) {
+	if _, known := wellKnown[v.Type()]; !known || !v.CanInterface() {
+		return v, false, nil
+	}
+
+	switch x := pointerTo(v).Interface().(type) {
+	case *big.Int:
+		return reflect.ValueOf(json.Number(x.String())), true, nil
+	case *url.URL:
+		return present(x.String()), true, nil
+	case *json.RawMessage:
+		if len(*x) == 0 {
+			return invalid, true, nil
+		}
+		_, doc, err := decodeDocument(*x)
+		return reflect.ValueOf(doc), true, err
+	case encoding.TextMarshaler:
+		text, err := x.MarshalText()
+		return present(string(text)), true, err
+	}
+	return v, false, nil
+}
+
+// present return the encoding of a value, zero values like a nil net.IP encode
+// to an empty string and are absent like a nil pointer
+func present(text string) reflect.Value {
+	if text == "" {
+		return invalid
+	}
+	return reflect.ValueOf(text)
+}